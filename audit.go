@@ -0,0 +1,219 @@
+package certauth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AuditEvent is a structured record of a single authorization decision
+type AuditEvent struct {
+	Time       time.Time `json:"time"`
+	Allowed    bool      `json:"allowed"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Method     string    `json:"method,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	// Route is the pattern of the route whose checkers decided the request, empty when the
+	// default checkers did
+	Route string `json:"route,omitempty"`
+
+	// Client certificate details. These are empty when the request had no verified client cert.
+	Subject     string `json:"subject,omitempty"`
+	Issuer      string `json:"issuer,omitempty"`
+	Serial      string `json:"serial,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+
+	// Groups holds the verdict of each checker group that was tried, in order
+	Groups []GroupVerdict `json:"groups,omitempty"`
+
+	// Reason is the error which caused the request to be denied
+	Reason string `json:"reason,omitempty"`
+
+	// Cached is set when the decision was reused from the decision cache, in which case Groups
+	// describes the original decision
+	Cached bool `json:"cached,omitempty"`
+
+	// Handshake is set when the client was denied during the TLS handshake by the checkers
+	// configured with WithHandshakeCheckers, in which case there is no HTTP request
+	Handshake bool `json:"handshake,omitempty"`
+
+	// Latency is the time taken to reach the decision
+	Latency time.Duration `json:"latency_ns"`
+}
+
+// GroupVerdict is the outcome of one checker group
+type GroupVerdict struct {
+	Group   int  `json:"group"`
+	Allowed bool `json:"allowed"`
+	// Checker is the index of the checker which denied the request, if it was denied
+	Checker int    `json:"checker"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// AuditSink receives an AuditEvent for every request processed by an Auth, allowed or denied.
+// Audit is called synchronously on the request path so implementations should be quick.
+type AuditSink interface {
+	Audit(ctx context.Context, ev AuditEvent)
+}
+
+// AuditSinkFunc allows an ordinary function to be used as an AuditSink
+type AuditSinkFunc func(ctx context.Context, ev AuditEvent)
+
+// Audit calls f(ctx, ev)
+func (f AuditSinkFunc) Audit(ctx context.Context, ev AuditEvent) {
+	f(ctx, ev)
+}
+
+// WithAuditSink configures an Auth to emit an AuditEvent to `sink` for every request
+func WithAuditSink(sink AuditSink) AuthOption {
+	return func(a *Auth) {
+		a.auditSink = sink
+	}
+}
+
+// audit builds and emits the AuditEvent for a request, if an AuditSink is configured, and ends
+// the request's span with it. `req` is nil when the request failed validation before a client
+// certificate was found and `span` is nil when the request isn't traced.
+func (a *Auth) audit(start time.Time, r *http.Request, req *AuthRequest, res evaluation, span Span) {
+	if a.auditSink == nil && span == nil {
+		return
+	}
+
+	ev := newAuditEvent(start, req, res)
+	ev.RemoteAddr = r.RemoteAddr
+	ev.Method = r.Method
+	if r.URL != nil {
+		ev.Path = r.URL.Path
+	}
+
+	if span != nil {
+		span.End(ev, res.err)
+	}
+	if a.auditSink != nil {
+		a.auditSink.Audit(r.Context(), ev)
+	}
+}
+
+// newAuditEvent describes a decision, without the details of the HTTP request
+func newAuditEvent(start time.Time, req *AuthRequest, res evaluation) AuditEvent {
+	ev := AuditEvent{
+		Time:    start,
+		Allowed: res.err == nil,
+		Route:   res.route,
+		Cached:  res.cached,
+		Latency: time.Since(start),
+	}
+	if res.err != nil {
+		ev.Reason = res.err.Error()
+	}
+	if req != nil && req.Identity != nil {
+		ev.Subject = req.Identity.Subject
+		ev.Issuer = req.Identity.Issuer
+		ev.Serial = req.Identity.SerialNumber
+		ev.Fingerprint = req.Identity.Fingerprint
+	}
+	for _, f := range res.failures {
+		ev.Groups = append(ev.Groups, GroupVerdict{
+			Group: f.Group, Checker: f.Checker, Reason: f.Err.Error(),
+		})
+	}
+	if res.group >= 0 {
+		ev.Groups = append(ev.Groups, GroupVerdict{Group: res.group, Allowed: true})
+	}
+	return ev
+}
+
+// SlogAuditSink is an AuditSink which logs events with log/slog. Allowed requests are logged at
+// Info level and denied requests at Warn level.
+type SlogAuditSink struct {
+	logger *slog.Logger
+}
+
+// NewSlogAuditSink returns an AuditSink logging to `logger`, or slog.Default() if nil
+func NewSlogAuditSink(logger *slog.Logger) *SlogAuditSink {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &SlogAuditSink{logger: logger}
+}
+
+// Audit implements AuditSink
+func (s *SlogAuditSink) Audit(ctx context.Context, ev AuditEvent) {
+	level, msg := slog.LevelInfo, "certauth request allowed"
+	if !ev.Allowed {
+		level, msg = slog.LevelWarn, "certauth request denied"
+	}
+
+	groups := make([]any, 0, len(ev.Groups))
+	for _, g := range ev.Groups {
+		attrs := []any{slog.Bool("allowed", g.Allowed)}
+		if !g.Allowed {
+			attrs = append(attrs, slog.Int("checker", g.Checker), slog.String("reason", g.Reason))
+		}
+		groups = append(groups, slog.Group(groupName(g.Group), attrs...))
+	}
+
+	s.logger.LogAttrs(ctx, level, msg,
+		slog.Bool("allowed", ev.Allowed),
+		slog.String("remote_addr", ev.RemoteAddr),
+		slog.String("method", ev.Method),
+		slog.String("path", ev.Path),
+		slog.String("route", ev.Route),
+		slog.String("subject", ev.Subject),
+		slog.String("issuer", ev.Issuer),
+		slog.String("serial", ev.Serial),
+		slog.String("fingerprint", ev.Fingerprint),
+		slog.String("reason", ev.Reason),
+		slog.Duration("latency", ev.Latency),
+		slog.Group("groups", groups...),
+	)
+}
+
+func groupName(i int) string {
+	return "group_" + strconv.Itoa(i)
+}
+
+// JSONLinesAuditSink is an AuditSink writing each event as a line of JSON
+type JSONLinesAuditSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	c   io.Closer
+}
+
+// NewJSONLinesAuditSink returns an AuditSink writing JSON lines to `w`
+func NewJSONLinesAuditSink(w io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{enc: json.NewEncoder(w)}
+}
+
+// OpenJSONLinesAuditFile returns an AuditSink appending JSON lines to the file at `path`, which is
+// created if it doesn't exist. Close the sink to close the file.
+func OpenJSONLinesAuditFile(path string) (*JSONLinesAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	s := NewJSONLinesAuditSink(f)
+	s.c = f
+	return s, nil
+}
+
+// Audit implements AuditSink. Write errors are dropped; an audit sink must not fail requests.
+func (s *JSONLinesAuditSink) Audit(ctx context.Context, ev AuditEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.enc.Encode(ev)
+}
+
+// Close closes the underlying file if the sink was created with OpenJSONLinesAuditFile
+func (s *JSONLinesAuditSink) Close() error {
+	if s.c == nil {
+		return nil
+	}
+	return s.c.Close()
+}
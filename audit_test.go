@@ -0,0 +1,163 @@
+package certauth_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+func auditRequest(auth *certauth.Auth, state *tls.ConnectionState) {
+	req, _ := http.NewRequest("POST", "https://foo.bar/foo/bar", nil)
+	req.RemoteAddr = "10.1.2.3:4567"
+	req.TLS = state
+	auth.Handler(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestAuditSink(t *testing.T) {
+	var events []certauth.AuditEvent
+	auth := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs(nil, []string{"admin"})),
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		certauth.WithAuditSink(certauth.AuditSinkFunc(func(ctx context.Context, ev certauth.AuditEvent) {
+			events = append(events, ev)
+		})),
+	)
+
+	auditRequest(auth, &tls.ConnectionState{VerifiedChains: headerTestCert()})
+	auditRequest(auth, &tls.ConnectionState{VerifiedChains: fakeCertChain(fakeCertData{[]string{"site"}, "foo.com"})})
+	auditRequest(auth, nil)
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 audit events, got %d", len(events))
+	}
+
+	allowed := events[0]
+	expect(t, allowed.Allowed, true)
+	expect(t, allowed.RemoteAddr, "10.1.2.3:4567")
+	expect(t, allowed.Method, "POST")
+	expect(t, allowed.Path, "/foo/bar")
+	expect(t, allowed.Route, "")
+	expect(t, allowed.Subject, "CN=foo.com,OU=endpoint+OU=titan")
+	expect(t, allowed.Issuer, "CN=Test CA")
+	expect(t, allowed.Serial, "beef")
+	expect(t, allowed.Fingerprint, "4765b990e273236d89418664d51837b9f0d0766b7ba129c698a3cb2225eb9cc6")
+	expect(t, allowed.Reason, "")
+	expect(t, len(allowed.Groups), 2)
+	expect(t, allowed.Groups[0], certauth.GroupVerdict{
+		Group: 0, Allowed: false, Reason: mkCNErr("foo.com", "admin").Error(),
+	})
+	expect(t, allowed.Groups[1], certauth.GroupVerdict{Group: 1, Allowed: true})
+	if allowed.Time.IsZero() || allowed.Latency <= 0 {
+		t.Errorf("expected time and latency to be set: %v %v", allowed.Time, allowed.Latency)
+	}
+
+	denied := events[1]
+	expect(t, denied.Allowed, false)
+	expect(t, denied.Reason, mkOUErr("site", "endpoint").Error())
+	expect(t, len(denied.Groups), 2)
+	expect(t, denied.Groups[1].Reason, denied.Reason)
+
+	noCert := events[2]
+	expect(t, noCert.Allowed, false)
+	expect(t, noCert.Reason, certauth.ErrNoClientCert.Error())
+	expect(t, noCert.Subject, "")
+	expect(t, len(noCert.Groups), 0)
+}
+
+func TestAuditSinkRoute(t *testing.T) {
+	var events []certauth.AuditEvent
+	auth := certauth.New(
+		certauth.WithRouteCheckers("POST /foo/{name}", certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		certauth.WithAuditSink(certauth.AuditSinkFunc(func(ctx context.Context, ev certauth.AuditEvent) {
+			events = append(events, ev)
+		})),
+	)
+
+	auditRequest(auth, &tls.ConnectionState{VerifiedChains: headerTestCert()})
+	auditRequest(auth, &tls.ConnectionState{VerifiedChains: fakeCertChain(fakeCertData{[]string{"site"}, "foo.com"})})
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 audit events, got %d", len(events))
+	}
+	expect(t, events[0].Allowed, true)
+	expect(t, events[0].Route, "POST /foo/{name}")
+	expect(t, events[1].Allowed, false)
+	expect(t, events[1].Route, "POST /foo/{name}")
+}
+
+func TestJSONLinesAuditSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	sink, err := certauth.OpenJSONLinesAuditFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		certauth.WithAuditSink(sink),
+	)
+
+	auditRequest(auth, &tls.ConnectionState{VerifiedChains: headerTestCert()})
+	auditRequest(auth, nil)
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid JSON line %q: %s", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	expect(t, len(lines), 2)
+	expect(t, lines[0]["allowed"], true)
+	expect(t, lines[0]["serial"], "beef")
+	expect(t, lines[0]["path"], "/foo/bar")
+	expect(t, lines[1]["allowed"], false)
+	expect(t, lines[1]["reason"], certauth.ErrNoClientCert.Error())
+}
+
+func TestSlogAuditSink(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	auth := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"site"}, nil)),
+		certauth.WithAuditSink(certauth.NewSlogAuditSink(logger)),
+	)
+
+	auditRequest(auth, &tls.ConnectionState{VerifiedChains: headerTestCert()})
+
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("invalid log record %q: %s", buf.String(), err)
+	}
+	expect(t, rec["level"], "WARN")
+	expect(t, rec["msg"], "certauth request denied")
+	expect(t, rec["subject"], "CN=foo.com,OU=endpoint+OU=titan")
+	groups := rec["groups"].(map[string]interface{})
+	group0 := groups["group_0"].(map[string]interface{})
+	expect(t, group0["allowed"], false)
+	if !strings.Contains(group0["reason"].(string), "cert failed OU validation") {
+		t.Errorf("unexpected group reason: %v", group0["reason"])
+	}
+}
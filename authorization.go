@@ -2,8 +2,6 @@ package certauth
 
 import (
 	"fmt"
-
-	"github.com/julienschmidt/httprouter"
 )
 
 // ContextKey and ContextValue are type aliases to make the code a bit more readable.
@@ -17,19 +15,22 @@ type ContextValue interface{}
 // If authorization is denied, the AuthorizationChecker should return an error value with some
 // description of why the request is being denied.
 // If the request is allowed, the AuthorizationChecker may return a map of key/value pairs.
-// These key/value pairs are added to the request's context using `context.WithValue` by the
-// middleware. Downstream applications can then use these values if desired.
+// These key/value pairs are added to the request's context by the middleware, see Claims.
+// Downstream applications can then use these values if desired with `ctx.Value(key)`.
 // See the methods for a description of which Allow* method is chosen depending on the
 // request.
+// Checkers which need the full certificate chain or the HTTP request should implement
+// RequestChecker instead.
 type AuthorizationChecker interface {
-	// CheckAuthorization is called for requests which do not use the `httprouter` framework.
+	// CheckAuthorization is called for requests which have no route params.
 	// `clientOU` and `clientCN` are set to the values determined from the x509 client certificate.
 	CheckAuthorization(clientOU []string, clientCN string) (map[ContextKey]ContextValue, error)
 
-	// CheckAuthorizationWithParams is called for requests which use the `httprouter` framework.
+	// CheckAuthorizationWithParams is called for requests which have route params, whichever
+	// router they come from, see Params.
 	// This allows the authorization behavior to respond to the resource that's being requested.
 	CheckAuthorizationWithParams(
-		clientOU []string, clientCN string, ps httprouter.Params,
+		clientOU []string, clientCN string, ps Params,
 	) (map[ContextKey]ContextValue, error)
 }
 
@@ -80,7 +81,7 @@ func (allow AllowSpecificOUandCNs) CheckAuthorization(
 }
 
 func (allow AllowSpecificOUandCNs) CheckAuthorizationWithParams(
-	clientOU []string, clientCN string, ps httprouter.Params,
+	clientOU []string, clientCN string, ps Params,
 ) (map[ContextKey]ContextValue, error) {
 	// URI parameters are not handled separately. Fall back to the behavior
 	// of CheckAuthorization
@@ -97,8 +98,8 @@ func allowedCN(allowedCNs []string, clientCN string) error {
 			return nil
 		}
 	}
-	return fmt.Errorf(
-		"cert failed CN validation for %q, allowed: %v", clientCN, allowedCNs)
+	return &ReasonError{ReasonCNMismatch, fmt.Errorf(
+		"cert failed CN validation for %q, allowed: %v", clientCN, allowedCNs)}
 }
 
 func allowedOU(allowedOUs []string, clientOUs []string) error {
@@ -109,6 +110,6 @@ func allowedOU(allowedOUs []string, clientOUs []string) error {
 			}
 		}
 	}
-	return fmt.Errorf(
-		"cert failed OU validation for %v, allowed: %v", clientOUs, allowedOUs)
+	return &ReasonError{ReasonOUMismatch, fmt.Errorf(
+		"cert failed OU validation for %v, allowed: %v", clientOUs, allowedOUs)}
 }
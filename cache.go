@@ -0,0 +1,227 @@
+package certauth
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheConfig is the configuration of an Auth's decision cache, see WithDecisionCache
+type CacheConfig struct {
+	// Size is the maximum number of decisions kept, the least recently used are evicted first.
+	// Defaults to 1024.
+	Size int
+
+	// TTL is how long an allowed decision is reused. Defaults to 1 minute.
+	TTL time.Duration
+
+	// NegativeTTL is how long a denied decision is reused. Denials are not cached if it is zero.
+	NegativeTTL time.Duration
+
+	// Params are the names of the route params included in the cache key. All params are
+	// included if it is nil, but only httprouter.Params, ParamsMap and PathValues can be listed:
+	// requests with the params of other routers are not cached unless Params is set.
+	Params []string
+
+	// Now returns the current time, defaults to time.Now. Useful for tests.
+	Now func() time.Time
+}
+
+// CacheStats is a snapshot of the activity of an Auth's decision cache
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	// Size is the number of decisions currently cached
+	Size int
+}
+
+// WithDecisionCache configures an Auth to cache its decisions so that repeated requests from the
+// same client don't run the checkers again. Decisions are keyed by the SHA-256 fingerprint of
+// the client's leaf certificate, the request method, the matching route (or the escaped path
+// when no route matches) and the route params.
+//
+// Only use the cache when the checkers decide based on those alone: a checker which looks at
+// e.g. the request headers would have its decision reused for requests it may have decided
+// differently. The cache is cleared when the checkers are replaced with SetCheckers, e.g. on a
+// policy reload, and can be cleared at any time with InvalidateCache.
+func WithDecisionCache(cfg CacheConfig) AuthOption {
+	return func(a *Auth) {
+		if cfg.Size <= 0 {
+			cfg.Size = 1024
+		}
+		if cfg.TTL == 0 {
+			cfg.TTL = time.Minute
+		}
+		if cfg.Now == nil {
+			cfg.Now = time.Now
+		}
+		a.cache = &decisionCache{
+			cfg:     cfg,
+			entries: make(map[string]*list.Element),
+			lru:     list.New(),
+		}
+	}
+}
+
+// CacheStats returns a snapshot of the activity of the decision cache. It is empty if the Auth
+// has no cache.
+func (a *Auth) CacheStats() CacheStats {
+	if a.cache == nil {
+		return CacheStats{}
+	}
+	return a.cache.stats()
+}
+
+// InvalidateCache removes every decision from the decision cache
+func (a *Auth) InvalidateCache() {
+	if a.cache != nil {
+		a.cache.clear()
+	}
+}
+
+type decisionCache struct {
+	cfg CacheConfig
+
+	hits, misses, evictions atomic.Uint64
+
+	mu      sync.Mutex
+	gen     uint64
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+type cacheEntry struct {
+	key     string
+	res     evaluation
+	expires time.Time
+}
+
+// key returns the cache key of a request, or "" if it can't be cached
+func (c *decisionCache) key(req *AuthRequest, scope string) string {
+	if req.Certificate == nil {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(sha256Hex(req.Certificate.Raw))
+	if req.Request != nil {
+		b.WriteString("\x00" + req.Request.Method)
+		if scope == "" && req.Request.URL != nil {
+			// checkers and routes match the escaped path, so must the key: "/a%2Fb" and "/a/b"
+			// are different requests
+			scope = req.Request.URL.EscapedPath()
+		}
+	}
+	b.WriteString("\x00" + scope)
+	if c.cfg.Params != nil {
+		for _, name := range c.cfg.Params {
+			if req.Params != nil {
+				b.WriteString("\x00" + name + "=" + req.Params.ByName(name))
+			}
+		}
+		return b.String()
+	}
+	pairs, ok := paramPairs(req.Params)
+	if !ok {
+		// the params of other routers can't be listed, configure CacheConfig.Params for them
+		return ""
+	}
+	for _, p := range pairs {
+		b.WriteString("\x00" + p[0] + "=" + p[1])
+	}
+	return b.String()
+}
+
+// generation is incremented every time the cache is cleared
+func (c *decisionCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// get returns a cached evaluation
+func (c *decisionCache) get(key string) (evaluation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		if c.cfg.Now().Before(entry.expires) {
+			c.lru.MoveToFront(el)
+			c.hits.Add(1)
+			return entry.res.clone(), true
+		}
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+	c.misses.Add(1)
+	return evaluation{}, false
+}
+
+// put caches an evaluation unless the cache was cleared since the generation was read
+func (c *decisionCache) put(key string, gen uint64, res evaluation) {
+	ttl := c.cfg.TTL
+	if res.err != nil {
+		ttl = c.cfg.NegativeTTL
+		if _, ok := res.err.(*AuthorizationError); !ok {
+			// e.g. the identity could not be extracted
+			return
+		}
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if gen != c.gen {
+		return
+	}
+	entry := &cacheEntry{key: key, res: res.clone(), expires: c.cfg.Now().Add(ttl)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.cfg.Size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+		c.evictions.Add(1)
+	}
+}
+
+func (c *decisionCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+func (c *decisionCache) stats() CacheStats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses.Load(),
+		Evictions: c.evictions.Load(),
+		Size:      size,
+	}
+}
+
+// clone copies the context values so requests sharing a cached evaluation can't affect each
+// other
+func (res evaluation) clone() evaluation {
+	if res.ctxParams != nil {
+		ctxParams := make(map[ContextKey]ContextValue, len(res.ctxParams))
+		for k, v := range res.ctxParams {
+			ctxParams[k] = v
+		}
+		res.ctxParams = ctxParams
+	}
+	return res
+}
@@ -0,0 +1,175 @@
+package certauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// countingChecker allows clients whose CN is in `allowed`, counting how often it runs
+type countingChecker struct {
+	allowed map[string]bool
+	calls   atomic.Int64
+}
+
+func (c *countingChecker) Check(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+	c.calls.Add(1)
+	if !c.allowed[req.Identity.CommonName] {
+		return certauth.Deny("not allowed"), nil
+	}
+	return certauth.Allow(certauth.Claims{ctxKey("cn"): req.Identity.CommonName}), nil
+}
+
+func cachedClient(cn string) [][]*x509.Certificate {
+	return [][]*x509.Certificate{{{Raw: []byte(cn), Subject: pkix.Name{CommonName: cn}}}}
+}
+
+func TestDecisionCache(t *testing.T) {
+	clock := time.Now()
+	checker := &countingChecker{allowed: map[string]bool{"foo.com": true}}
+	var events []certauth.AuditEvent
+	auth := certauth.New(
+		certauth.WithRequestCheckers(checker),
+		certauth.WithDecisionCache(certauth.CacheConfig{
+			Size:        2,
+			TTL:         time.Minute,
+			NegativeTTL: 10 * time.Second,
+			Now:         func() time.Time { return clock },
+		}),
+		certauth.WithAuditSink(certauth.AuditSinkFunc(func(ctx context.Context, ev certauth.AuditEvent) {
+			events = append(events, ev)
+		})),
+	)
+
+	var cn interface{}
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cn = r.Context().Value(ctxKey("cn"))
+	}))
+	serve := func(client, path string, expCode int) {
+		t.Helper()
+		cn = nil
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "https://foo.bar"+path, nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: cachedClient(client)}
+		handler.ServeHTTP(w, req)
+		expect(t, w.Code, expCode)
+		if expCode == http.StatusOK {
+			expect(t, cn, client)
+		}
+	}
+	expectCalls := func(exp int64) {
+		t.Helper()
+		expect(t, checker.calls.Load(), exp)
+	}
+
+	serve("foo.com", "/a", http.StatusOK)
+	serve("foo.com", "/a", http.StatusOK)
+	expectCalls(1)
+	expect(t, events[1].Cached, true)
+
+	// a different path or client is a different decision
+	serve("foo.com", "/b", http.StatusOK)
+	expectCalls(2)
+
+	// denials are cached for the NegativeTTL
+	serve("bar.com", "/a", http.StatusForbidden)
+	serve("bar.com", "/a", http.StatusForbidden)
+	expectCalls(3)
+	clock = clock.Add(11 * time.Second)
+	serve("bar.com", "/a", http.StatusForbidden)
+	expectCalls(4)
+
+	// the least recently used decision was evicted (foo.com /a)
+	serve("foo.com", "/b", http.StatusOK)
+	expectCalls(4)
+	serve("foo.com", "/a", http.StatusOK)
+	expectCalls(5)
+
+	// allowed decisions expire after the TTL
+	clock = clock.Add(time.Minute)
+	serve("foo.com", "/a", http.StatusOK)
+	expectCalls(6)
+
+	stats := auth.CacheStats()
+	expect(t, stats.Hits, uint64(3))
+	expect(t, stats.Misses, uint64(6))
+	expect(t, stats.Size, 2)
+	if stats.Evictions == 0 {
+		t.Error("expected evictions")
+	}
+
+	// replacing the checkers invalidates the cache
+	checker.allowed = map[string]bool{"bar.com": true}
+	auth.SetCheckers([]certauth.RequestChecker{checker})
+	expect(t, auth.CacheStats().Size, 0)
+	serve("foo.com", "/a", http.StatusForbidden)
+	serve("bar.com", "/a", http.StatusOK)
+	expectCalls(8)
+
+	auth.InvalidateCache()
+	serve("bar.com", "/a", http.StatusOK)
+	expectCalls(9)
+}
+
+func TestDecisionCacheParams(t *testing.T) {
+	checker := &countingChecker{allowed: map[string]bool{"foo.com": true}}
+	auth := certauth.New(
+		certauth.WithRoute("/sites/{site}/envs/{env}", checker),
+		certauth.WithDecisionCache(certauth.CacheConfig{Params: []string{"site"}}),
+	)
+
+	authorize := func(path string) {
+		t.Helper()
+		_, err := auth.Authorize(context.Background(), &certauth.AuthRequest{
+			Certificate: cachedClient("foo.com")[0][0],
+			Request:     httptest.NewRequest("GET", "https://foo.bar"+path, nil),
+		})
+		expectErr(t, err, nil)
+	}
+	authorize("/sites/a/envs/dev")
+	authorize("/sites/a/envs/live") // env is not part of the key
+	expect(t, checker.calls.Load(), int64(1))
+	authorize("/sites/b/envs/dev")
+	expect(t, checker.calls.Load(), int64(2))
+}
+
+func TestDecisionCacheEscapedPath(t *testing.T) {
+	var calls atomic.Int64
+	checker := certauth.RequestCheckerFunc(func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+		calls.Add(1)
+		if req.Request.URL.EscapedPath() != "/public/admin%2Fx" {
+			return certauth.Deny("not allowed"), nil
+		}
+		return certauth.Allow(nil), nil
+	})
+	auth := certauth.New(
+		certauth.WithRequestCheckers(checker),
+		certauth.WithDecisionCache(certauth.CacheConfig{NegativeTTL: time.Minute}),
+	)
+
+	authorize := func(path string, expErr bool) {
+		t.Helper()
+		_, err := auth.Authorize(context.Background(), &certauth.AuthRequest{
+			Certificate: cachedClient("foo.com")[0][0],
+			Request:     httptest.NewRequest("GET", "https://foo.bar"+path, nil),
+		})
+		if (err != nil) != expErr {
+			t.Fatalf("%s: unexpected error %v", path, err)
+		}
+	}
+	authorize("/public/admin%2Fx", false)
+	// same unescaped path, but a different request
+	authorize("/public/admin/x", true)
+	expect(t, calls.Load(), int64(2))
+	authorize("/public/admin%2Fx", false)
+	authorize("/public/admin/x", true)
+	expect(t, calls.Load(), int64(2))
+}
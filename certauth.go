@@ -3,9 +3,12 @@ package certauth
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -23,6 +26,10 @@ const (
 
 	//HasAuthorizedCN is used as the request context key, adding info about the authroized CN if authorization succeeded
 	HasAuthorizedCN = contextKey("Has Authorized CN")
+
+	// IdentityKey is used as the request context key holding the authorized client's *Identity,
+	// see IdentityFromContext
+	IdentityKey = contextKey("Identity")
 )
 
 // TODO:(jnelson) Maybe a standardValidation method for our stuff? Thu May 14 18:41:41 2015
@@ -64,9 +71,27 @@ type Options struct {
 type Auth struct {
 	opt Options // **DEPRECATED**
 	// lists of checkers: auth if any list passes, a list passes if all checkers in the list pass
-	checkers     [][]AuthorizationChecker
+	// guarded by mu so they can be replaced while serving, see SetCheckers
+	mu           sync.RWMutex
+	checkers     [][]RequestChecker
 	setHeaders   bool
+	headerPrefix string
+	headerNames  HeaderNames
+	extractor    IdentityExtractor
+	auditSink    AuditSink
 	errorHandler http.Handler
+	// route table consulted before the default checkers, see WithRoute
+	routes []*route
+	// configuration errors reported by the options, see Build
+	errs []error
+	// optional, see WithDecisionCache
+	cache *decisionCache
+	// optional, see WithMetrics
+	metrics Metrics
+	// optional, see WithTracer
+	tracer Tracer
+	// checked during the TLS handshake, see WithHandshakeCheckers
+	handshakeCheckers [][]RequestChecker
 }
 
 // AuthOption is a type of function for configuring an Auth
@@ -77,17 +102,42 @@ type AuthOption func(*Auth)
 // eg: New(WithCheckers(A), WithCheckers(B,C)) will pass on `A || (B && C)`
 func WithCheckers(checkers ...AuthorizationChecker) AuthOption {
 	return func(a *Auth) {
-		a.checkers = append(a.checkers, checkers)
+		a.checkers = append(a.checkers, adaptCheckers(checkers))
 	}
 
 }
 
+// WithHeaders configures an Auth to populate request headers with the verified client identity
+// (subject, CN, OUs, SANs, serial, fingerprint and issuer) along with any context values whose
+// keys implement HeaderKey. Lists, such as the OUs and SANs, are set as one header per value; use
+// http.Header.Values to read them. Any client supplied copies of these headers are removed first
+// so they cannot be spoofed; checkers list the HeaderKeys they may set with HeaderKeyProvider.
 func WithHeaders() AuthOption {
 	return func(a *Auth) {
 		a.setHeaders = true
 	}
 }
 
+// WithHeaderPrefix enables header injection (see WithHeaders) using the given prefix in place of
+// DefaultHeaderPrefix. All request headers starting with the prefix are removed before the
+// identity headers are set; with an empty prefix only the configured names and the HeaderKeys
+// declared by the checkers are.
+func WithHeaderPrefix(prefix string) AuthOption {
+	return func(a *Auth) {
+		a.setHeaders = true
+		a.headerPrefix = prefix
+	}
+}
+
+// WithHeaderNames enables header injection (see WithHeaders) using the given header names.
+// Names left empty use the value from DefaultHeaderNames.
+func WithHeaderNames(names HeaderNames) AuthOption {
+	return func(a *Auth) {
+		a.setHeaders = true
+		a.headerNames = names.withDefaults()
+	}
+}
+
 func WithErrorHandler(handler http.Handler) AuthOption {
 	return func(a *Auth) {
 		a.errorHandler = handler
@@ -95,14 +145,31 @@ func WithErrorHandler(handler http.Handler) AuthOption {
 
 }
 
+// New creates an Auth configured with `opts`. It panics if an option is invalid, e.g. a route
+// pattern which can't be parsed; use Build to handle the error instead.
 func New(opts ...AuthOption) *Auth {
+	a, err := Build(opts...)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Build is like New but returns an error if an option is invalid
+func Build(opts ...AuthOption) (*Auth, error) {
 	a := &Auth{
 		errorHandler: http.HandlerFunc(defaultAuthErrorHandler),
+		headerPrefix: DefaultHeaderPrefix,
+		headerNames:  DefaultHeaderNames,
+		extractor:    ExtractIdentity,
 	}
 	for _, opt := range opts {
 		opt(a)
 	}
-	return a
+	if len(a.errs) > 0 {
+		return nil, errors.Join(a.errs...)
+	}
+	return a, nil
 }
 
 // **DEPRECATED** use New instead
@@ -128,7 +195,11 @@ func NewAuth(opts ...Options) *Auth {
 	return &Auth{
 		opt:          o,
 		errorHandler: http.HandlerFunc(h),
-		checkers:     [][]AuthorizationChecker{o.AuthorizationCheckers},
+		checkers:     [][]RequestChecker{adaptCheckers(o.AuthorizationCheckers)},
+		setHeaders:   o.SetReqHeaders,
+		headerPrefix: DefaultHeaderPrefix,
+		headerNames:  DefaultHeaderNames,
+		extractor:    ExtractIdentity,
 	}
 }
 
@@ -178,46 +249,90 @@ func (a *Auth) Process(w http.ResponseWriter, r *http.Request) (*http.Request, e
 // configured AuthorizationCheckers.
 // Returns an http.Request with additional context values applied, or an error if
 // something went wrong.
+// When header injection is enabled the identity headers are also set on the request.
 func (a *Auth) ProcessWithParams(
-	w http.ResponseWriter, r *http.Request, ps httprouter.Params,
+	w http.ResponseWriter, r *http.Request, ps Params,
 ) (*http.Request, error) {
-	if err := a.ValidateRequest(r); err != nil {
-		return nil, err
+	if a.setHeaders {
+		if r.Header == nil {
+			r.Header = make(http.Header)
+		}
+		a.stripHeaders(r.Header)
 	}
 
-	ctxParams, err := a.CheckAuthorization(r.TLS.VerifiedChains[0][0], ps)
+	id, claims, err := a.AuthorizeRequest(r, ps)
 	if err != nil {
-		a.errorHandler.ServeHTTP(w, r)
+		a.fail(w, r, err)
 		return nil, err
 	}
 
-	if len(ctxParams) == 0 {
-		// No need to update the context; just return the one we already have
-		return r, nil
+	if a.setHeaders {
+		a.setHeaderValues(r.Header, id, claims)
+	}
+
+	// Replace the context on the request object with one holding the identity and the additional
+	// values
+	return r.WithContext(withClaims(r.Context(), id, claims)), nil
+}
+
+// AuthorizeRequest validates the client certificate of `r` and runs the checkers against it,
+// returning the client's identity and claims, or the error it was rejected with. The decision is
+// reported to the AuditSink, Metrics and Tracer like those of ProcessWithParams, but the request
+// headers are left alone and the error handler isn't called. It is meant for transports which
+// describe their calls as HTTP requests without serving them with an http.Handler, e.g. the
+// grpcauth interceptors.
+func (a *Auth) AuthorizeRequest(r *http.Request, ps Params) (*Identity, Claims, error) {
+	start := time.Now()
+	ctx, span := a.startSpan(r)
+
+	if err := a.ValidateRequest(r); err != nil {
+		a.audit(start, r, nil, evaluation{group: -1, err: err}, span)
+		a.observe(nil, err)
+		return nil, nil, err
 	}
 
-	// Prepare a new context with the additional values
-	ctx := r.Context()
-	for k, v := range ctxParams {
-		ctx = context.WithValue(ctx, k, v)
+	req := &AuthRequest{
+		Certificate:    r.TLS.VerifiedChains[0][0],
+		VerifiedChains: r.TLS.VerifiedChains,
+		Request:        r,
+		Params:         ps,
+	}
+	res := a.authorize(ctx, req)
+	a.audit(start, r, req, res, span)
+	a.observe(req.Certificate, res.err)
+	if res.err != nil {
+		return nil, nil, res.err
 	}
+	return req.Identity, res.ctxParams, nil
+}
 
-	// Replace the context on the request object
-	return r.WithContext(ctx), nil
+// fail hands a rejected request to the error handler, making the error available through
+// ErrorFromContext
+func (a *Auth) fail(w http.ResponseWriter, r *http.Request, err error) {
+	a.errorHandler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), AuthErrorKey, err)))
 }
 
 // ValidateRequest performs verification on the TLS certs and chain
+// Returns ErrNoClientCert or ErrChainMismatch if the request can't be processed.
 func (a *Auth) ValidateRequest(r *http.Request) error {
 	// ensure we can process this request
-	if r.TLS == nil || r.TLS.VerifiedChains == nil {
-		return errors.New("no cert chain detected")
+	if r.TLS == nil {
+		return ErrNoClientCert
+	}
+	return validateConnectionState(r.TLS)
+}
+
+// validateConnectionState checks that a connection has a verified client certificate chain
+func validateConnectionState(cs *tls.ConnectionState) error {
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		return ErrNoClientCert
 	}
 
 	// TODO: Figure out if having multiple validated peer leaf certs is possible. For now, only validate
 	// one cert, and make sure it matches the first peer certificate
-	if r.TLS.PeerCertificates != nil {
-		if !bytes.Equal(r.TLS.PeerCertificates[0].Raw, r.TLS.VerifiedChains[0][0].Raw) {
-			return errors.New("first peer certificate not first verified chain leaf")
+	if len(cs.PeerCertificates) > 0 {
+		if !bytes.Equal(cs.PeerCertificates[0].Raw, cs.VerifiedChains[0][0].Raw) {
+			return ErrChainMismatch
 		}
 	}
 
@@ -226,39 +341,28 @@ func (a *Auth) ValidateRequest(r *http.Request) error {
 
 // CheckAuthorization runs each of the AuthorizationCheckers configured for the server
 // and returns an error if any of them return False.
+// The client's identity is built from `verifiedCert` with the configured IdentityExtractor.
 // See the documentation for AuthorizationChecker for more details.
 func (a *Auth) CheckAuthorization(
-	verifiedCert *x509.Certificate, ps httprouter.Params,
+	verifiedCert *x509.Certificate, ps Params,
 ) (map[ContextKey]ContextValue, error) {
-	ou := verifiedCert.Subject.OrganizationalUnit
-	cn := verifiedCert.Subject.CommonName
-
-	ctxParams := make(map[ContextKey]ContextValue)
-	var (
-		params map[ContextKey]ContextValue
-		err    error
-	)
-	for _, cks := range a.checkers { // trying all the groups of checkers
-		for _, ck := range cks { // each checker in a group
-			if ps == nil { // not using httprouter
-				params, err = ck.CheckAuthorization(ou, cn)
-			} else { // using httprouter
-				params, err = ck.CheckAuthorizationWithParams(ou, cn, ps)
-			}
-			if err != nil { // stop trying checkers in this group if one fails
-				break
-			}
-			// Collect the context params from each AuthorizationChecker into one map
-			if params != nil {
-				for k, v := range params {
-					ctxParams[k] = v
-				}
-			}
-		}
-		// non-nil when a group doesn't pass, so nil means a group passed, so we're done
-		if err == nil {
-			break
-		}
+	id, err := a.extractor(verifiedCert)
+	if err != nil {
+		return nil, err
 	}
-	return ctxParams, err
+	return a.CheckIdentity(id, ps)
+}
+
+// CheckIdentity runs each of the AuthorizationCheckers configured for the server against an
+// already extracted client identity and returns an error if any of them return False.
+// AuthorizationCheckers which implement IdentityChecker receive the full Identity, others receive
+// its OrganizationalUnits and CommonName.
+func (a *Auth) CheckIdentity(
+	id *Identity, ps Params,
+) (map[ContextKey]ContextValue, error) {
+	return a.Authorize(context.Background(), &AuthRequest{
+		Identity:    id,
+		Certificate: id.Certificate,
+		Params:      ps,
+	})
 }
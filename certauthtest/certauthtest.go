@@ -0,0 +1,231 @@
+// Package certauthtest provides an in-memory certificate authority for testing code using
+// certauth, so tests don't depend on certificate files which expire.
+//
+//	ca := certauthtest.NewCA(t)
+//	server := ca.NewTLSServer(t, auth.Handler(handler))
+//	client := ca.Client(t, ca.IssueClient(t, certauthtest.Options{
+//		OrganizationalUnits: []string{"endpoint"},
+//		CommonName:          "foo.com",
+//	}))
+//	resp, err := client.Get(server.URL)
+//
+// Handlers can also be called directly with requests carrying verified chains, as if they had
+// been received over a TLS connection:
+//
+//	req := ca.NewRequest(t, "GET", "/foo", certauthtest.Options{CommonName: "foo.com"})
+//	auth.Handler(handler).ServeHTTP(httptest.NewRecorder(), req)
+package certauthtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Options describes a certificate issued by a CA
+type Options struct {
+	OrganizationalUnits []string
+	CommonName          string
+	Organizations       []string
+
+	DNSNames       []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	EmailAddresses []string
+
+	// NotBefore and NotAfter bound the validity of the certificate. They default to an hour ago
+	// and a day from now.
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// CA is an in-memory certificate authority
+type CA struct {
+	// Certificate is the CA's self-signed certificate
+	Certificate *x509.Certificate
+
+	key    *ecdsa.PrivateKey
+	serial atomic.Int64
+}
+
+// NewCA creates a CA with a new key
+func NewCA(t testing.TB) *CA {
+	t.Helper()
+	key := newKey(t)
+	ca := &CA{key: key}
+	tmpl := ca.template(Options{CommonName: "certauthtest CA"})
+	tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca.Certificate, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// Pool returns a pool holding the CA's certificate, to verify the certificates it issues
+func (ca *CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate)
+	return pool
+}
+
+// Issue issues a certificate valid for both client and server authentication
+func (ca *CA) Issue(t testing.TB, opts Options) tls.Certificate {
+	t.Helper()
+	return ca.issue(t, opts, x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth)
+}
+
+// IssueClient issues a client certificate
+func (ca *CA) IssueClient(t testing.TB, opts Options) tls.Certificate {
+	t.Helper()
+	return ca.issue(t, opts, x509.ExtKeyUsageClientAuth)
+}
+
+// IssueServer issues a server certificate. Without DNSNames or IPAddresses it is valid for
+// localhost and the loopback addresses, where httptest servers listen.
+func (ca *CA) IssueServer(t testing.TB, opts Options) tls.Certificate {
+	t.Helper()
+	if len(opts.DNSNames) == 0 && len(opts.IPAddresses) == 0 {
+		opts.DNSNames = []string{"localhost"}
+		opts.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	}
+	return ca.issue(t, opts, x509.ExtKeyUsageServerAuth)
+}
+
+func (ca *CA) issue(t testing.TB, opts Options, usage ...x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key := newKey(t)
+	tmpl := ca.template(opts)
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = usage
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Certificate, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func (ca *CA) template(opts Options) *x509.Certificate {
+	if opts.NotBefore.IsZero() {
+		opts.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if opts.NotAfter.IsZero() {
+		opts.NotAfter = time.Now().Add(24 * time.Hour)
+	}
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial.Add(1)),
+		Subject: pkix.Name{
+			OrganizationalUnit: opts.OrganizationalUnits,
+			CommonName:         opts.CommonName,
+			Organization:       opts.Organizations,
+		},
+		DNSNames:       opts.DNSNames,
+		IPAddresses:    opts.IPAddresses,
+		URIs:           opts.URIs,
+		EmailAddresses: opts.EmailAddresses,
+		NotBefore:      opts.NotBefore,
+		NotAfter:       opts.NotAfter,
+	}
+}
+
+func newKey(t testing.TB) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// ServerConfig returns a server TLS configuration presenting a certificate issued by the CA and
+// requiring clients to present one too
+func (ca *CA) ServerConfig(t testing.TB) *tls.Config {
+	t.Helper()
+	return &tls.Config{
+		Certificates: []tls.Certificate{ca.IssueServer(t, Options{CommonName: "localhost"})},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.Pool(),
+	}
+}
+
+// NewUnstartedTLSServer returns an httptest server using ServerConfig, which may be adjusted,
+// e.g. to set VerifyConnection, before calling StartTLS. It is closed when the test ends.
+func (ca *CA) NewUnstartedTLSServer(t testing.TB, handler http.Handler) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = ca.ServerConfig(t)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// NewTLSServer starts an httptest server requiring client certificates issued by the CA. It is
+// closed when the test ends.
+func (ca *CA) NewTLSServer(t testing.TB, handler http.Handler) *httptest.Server {
+	t.Helper()
+	server := ca.NewUnstartedTLSServer(t, handler)
+	server.StartTLS()
+	return server
+}
+
+// ClientConfig returns a client TLS configuration presenting `cert` and trusting the CA
+func (ca *CA) ClientConfig(cert tls.Certificate) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      ca.Pool(),
+	}
+}
+
+// Client returns an *http.Client presenting `cert` to servers with a certificate issued by the
+// CA, such as those started with NewTLSServer
+func (ca *CA) Client(t testing.TB, cert tls.Certificate) *http.Client {
+	t.Helper()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = ca.ClientConfig(cert)
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport}
+}
+
+// ConnectionState returns the state of a TLS connection whose client presented `cert`, as built
+// by crypto/tls once it verified the certificate against the CA
+func (ca *CA) ConnectionState(cert tls.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		Version:           tls.VersionTLS13,
+		HandshakeComplete: true,
+		ServerName:        "localhost",
+		PeerCertificates:  []*x509.Certificate{cert.Leaf},
+		VerifiedChains:    [][]*x509.Certificate{{cert.Leaf, ca.Certificate}},
+	}
+}
+
+// NewRequest returns a request for `target`, see httptest.NewRequest, received over a TLS
+// connection from a client presenting a certificate issued with `opts`
+func (ca *CA) NewRequest(t testing.TB, method, target string, opts Options) *http.Request {
+	t.Helper()
+	return ca.NewRequestWithCert(method, target, ca.IssueClient(t, opts))
+}
+
+// NewRequestWithCert is like NewRequest for a client presenting `cert`
+func (ca *CA) NewRequestWithCert(method, target string, cert tls.Certificate) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.TLS = ca.ConnectionState(cert)
+	return req
+}
@@ -0,0 +1,122 @@
+package certauthtest_test
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/certauthtest"
+)
+
+func TestIssue(t *testing.T) {
+	ca := certauthtest.NewCA(t)
+	spiffe, _ := url.Parse("spiffe://example.org/worker")
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	cert := ca.Issue(t, certauthtest.Options{
+		OrganizationalUnits: []string{"endpoint", "titan"},
+		CommonName:          "foo.com",
+		DNSNames:            []string{"foo.com"},
+		IPAddresses:         []net.IP{net.IPv4(10, 0, 0, 1)},
+		URIs:                []*url.URL{spiffe},
+		NotAfter:            notAfter,
+	})
+
+	expect(t, cert.Leaf.Subject.CommonName, "foo.com")
+	// the OUs are encoded as a DER set, which sorts them
+	ous := cert.Leaf.Subject.OrganizationalUnit
+	sort.Strings(ous)
+	expect(t, fmt.Sprint(ous), "[endpoint titan]")
+	expect(t, cert.Leaf.DNSNames[0], "foo.com")
+	expect(t, cert.Leaf.IPAddresses[0].String(), "10.0.0.1")
+	expect(t, cert.Leaf.URIs[0].String(), "spiffe://example.org/worker")
+	expect(t, cert.Leaf.NotAfter.Equal(notAfter), true)
+	_, err := cert.Leaf.Verify(x509.VerifyOptions{
+		Roots:     ca.Pool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// serials are unique
+	other := ca.IssueClient(t, certauthtest.Options{CommonName: "foo.com"})
+	expect(t, other.Leaf.SerialNumber.Cmp(cert.Leaf.SerialNumber) != 0, true)
+}
+
+func TestNewTLSServer(t *testing.T) {
+	ca := certauthtest.NewCA(t)
+	auth := certauth.New(certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)))
+	server := ca.NewTLSServer(t, auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := certauth.IdentityFromContext(r.Context())
+		io.WriteString(w, id.CommonName)
+	})))
+
+	get := func(opts certauthtest.Options) (*http.Response, error) {
+		t.Helper()
+		return ca.Client(t, ca.IssueClient(t, opts)).Get(server.URL)
+	}
+
+	resp, err := get(certauthtest.Options{OrganizationalUnits: []string{"endpoint"}, CommonName: "foo.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	expect(t, resp.StatusCode, http.StatusOK)
+	expect(t, string(body), "foo.com")
+
+	resp, err = get(certauthtest.Options{OrganizationalUnits: []string{"site"}, CommonName: "foo.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	expect(t, resp.StatusCode, http.StatusForbidden)
+
+	// expired certificates fail the handshake
+	_, err = get(certauthtest.Options{
+		OrganizationalUnits: []string{"endpoint"},
+		NotBefore:           time.Now().Add(-2 * time.Hour),
+		NotAfter:            time.Now().Add(-time.Hour),
+	})
+	if err == nil {
+		t.Error("expected an expired certificate to be rejected")
+	}
+
+	// as are certificates from another CA
+	_, err = ca.Client(t, certauthtest.NewCA(t).IssueClient(t, certauthtest.Options{})).Get(server.URL)
+	if err == nil {
+		t.Error("expected a certificate from another CA to be rejected")
+	}
+}
+
+func TestNewRequest(t *testing.T) {
+	ca := certauthtest.NewCA(t)
+	auth := certauth.New(certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)))
+	handler := auth.Handler(http.NotFoundHandler())
+
+	req := ca.NewRequest(t, "GET", "/foo", certauthtest.Options{OrganizationalUnits: []string{"endpoint"}})
+	expect(t, len(req.TLS.VerifiedChains[0]), 2)
+	expect(t, req.TLS.VerifiedChains[0][1], ca.Certificate)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	expect(t, w.Code, http.StatusNotFound)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, ca.NewRequest(t, "GET", "/foo", certauthtest.Options{OrganizationalUnits: []string{"site"}}))
+	expect(t, w.Code, http.StatusForbidden)
+}
+
+func expect(t *testing.T, a interface{}, b interface{}) {
+	t.Helper()
+	if a != b {
+		t.Errorf("Expected [%v] (type %T) - Got [%v] (type %T)", b, b, a, a)
+	}
+}
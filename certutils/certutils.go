@@ -62,6 +62,24 @@ type TLSServerConfig struct {
 	Port           int
 	Router         http.Handler
 	TLSConfigLevel TLSConfigLevel
+
+	// VerifyPeerCertificate, if set, is called after the client certificate has been verified
+	// against CertPool, e.g. to check revocation. See tls.Config.VerifyPeerCertificate.
+	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
+	// VerifyConnection, if set, is called once the handshake has verified the client
+	// certificate, e.g. certauth.Auth.VerifyConnection to reject unauthorized clients before
+	// they send a request. See tls.Config.VerifyConnection.
+	VerifyConnection func(tls.ConnectionState) error
+
+	// Reloader, if set, supplies the CA pool (in place of CertPool) and the server keypair,
+	// picking up changes to their files without a restart. When the Reloader has a keypair the
+	// server can be started with ListenAndServeTLS("", ""); otherwise pass the keypair files.
+	//
+	// With a CA pool, client certificates are verified against the current pool in
+	// VerifyConnection, and the server's Handler and ConnContext set the verified chains on
+	// each request. Replacing either drops them, and certauth then rejects every request.
+	Reloader *Reloader
 }
 
 // NewTLSServer sets up a Pantheon(TM) type of tls server that Requires and Verifies peer cert
@@ -72,6 +90,18 @@ func NewTLSServer(config TLSServerConfig) *http.Server {
 	// By default this server will require client MTLS certs and verify cert validity against the config.CertPool CA bundle
 	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 	tlsConfig.ClientCAs = config.CertPool
+	tlsConfig.VerifyPeerCertificate = config.VerifyPeerCertificate
+	tlsConfig.VerifyConnection = config.VerifyConnection
+
+	reloadCAs := config.Reloader != nil && config.Reloader.CertPool() != nil
+	if config.Reloader != nil {
+		if config.Reloader.Certificate() != nil {
+			tlsConfig.GetCertificate = config.Reloader.GetCertificate
+		}
+		if reloadCAs {
+			config.Reloader.verifyClients(tlsConfig)
+		}
+	}
 
 	// Setup client authentication
 	server := &http.Server{
@@ -83,6 +113,10 @@ func NewTLSServer(config TLSServerConfig) *http.Server {
 		Addr:              fmt.Sprintf("%s:%d", config.BindAddress, config.Port),
 		Handler:           config.Router,
 	}
+	if reloadCAs {
+		server.ConnContext = connVerifiedChains
+		server.Handler = config.Reloader.withVerifiedChains(config.Router)
+	}
 	return server
 }
 
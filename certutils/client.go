@@ -0,0 +1,163 @@
+//go:build go1.8
+// +build go1.8
+
+package certutils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// TLSClientConfig is the configuration you use to create a TLS client presenting a client
+// certificate
+type TLSClientConfig struct {
+	// CertFile and KeyFile are the keypair presented to servers. Optional, but must be set
+	// together.
+	CertFile string
+	KeyFile  string
+
+	// CAFile is the CA bundle used to verify server certificates, CertPool may be set instead.
+	// The system roots are used if neither is set.
+	CAFile   string
+	CertPool *x509.CertPool
+
+	// Reloader, if set, supplies the CA pool and the client keypair in place of the files
+	// above. The keypair is picked up on each handshake, so rotated certificates are presented
+	// without recreating the client; the CA pool is the one loaded when the client is created.
+	Reloader *Reloader
+
+	TLSConfigLevel TLSConfigLevel
+
+	// ServerName overrides the name used to verify the server certificate, which defaults to
+	// the host being dialed
+	ServerName string
+
+	// ServerIdentity, if set, pins the identity the server certificate must present on top of
+	// being verified against the CA pool
+	ServerIdentity *ServerIdentity
+
+	// VerifyConnection, if set, is called once the handshake has verified the server
+	// certificate, e.g. certauth.Auth.VerifyServer to apply checkers to the server's identity.
+	// See tls.Config.VerifyConnection.
+	VerifyConnection func(tls.ConnectionState) error
+
+	// Timeout is the http.Client timeout of clients created with NewTLSClient. Defaults to no
+	// timeout.
+	Timeout time.Duration
+}
+
+// ServerIdentity is the identity a client expects its server to present. Each field which is set
+// must match: the server certificate must hold at least one of its values.
+type ServerIdentity struct {
+	// DNSNames are the accepted DNS SANs
+	DNSNames []string
+	// URIs are the accepted URI SANs, e.g. SPIFFE IDs such as spiffe://example.org/api
+	URIs []string
+	// OrganizationalUnits are the accepted subject OUs
+	OrganizationalUnits []string
+}
+
+// Verify checks that `cert` matches the identity
+func (id *ServerIdentity) Verify(cert *x509.Certificate) error {
+	if len(id.DNSNames) > 0 && !anyMatch(id.DNSNames, cert.DNSNames) {
+		return fmt.Errorf("server certificate DNS names %v do not match %v", cert.DNSNames, id.DNSNames)
+	}
+	if len(id.URIs) > 0 {
+		uris := make([]string, len(cert.URIs))
+		for i, u := range cert.URIs {
+			uris[i] = u.String()
+		}
+		if !anyMatch(id.URIs, uris) {
+			return fmt.Errorf("server certificate URIs %v do not match %v", uris, id.URIs)
+		}
+	}
+	if len(id.OrganizationalUnits) > 0 && !anyMatch(id.OrganizationalUnits, cert.Subject.OrganizationalUnit) {
+		return fmt.Errorf(
+			"server certificate OUs %v do not match %v", cert.Subject.OrganizationalUnit, id.OrganizationalUnits,
+		)
+	}
+	return nil
+}
+
+func anyMatch(expected, actual []string) bool {
+	for _, e := range expected {
+		for _, a := range actual {
+			if e == a {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NewClientTLSConfig returns a *tls.Config for clients presenting a certificate to servers
+// requiring one, such as those created with NewTLSServer. It returns an error if the configured
+// files can't be loaded.
+func NewClientTLSConfig(config TLSClientConfig) (*tls.Config, error) {
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		return nil, errors.New("client requires both a cert file and a key file")
+	}
+
+	tlsConfig := NewTLSConfig(config.TLSConfigLevel)
+	tlsConfig.ServerName = config.ServerName
+	tlsConfig.RootCAs = config.CertPool
+
+	if config.CAFile != "" {
+		pool, err := LoadCACertFile(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.CertFile != "" {
+		cert, err := LoadKeyCertFiles(config.KeyFile, config.CertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if config.Reloader != nil {
+		if pool := config.Reloader.CertPool(); pool != nil {
+			tlsConfig.RootCAs = pool
+		}
+		if config.Reloader.Certificate() != nil {
+			tlsConfig.Certificates = nil
+			tlsConfig.GetClientCertificate = config.Reloader.GetClientCertificate
+		}
+	}
+
+	identity, verify := config.ServerIdentity, config.VerifyConnection
+	if identity != nil {
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("server presented no certificate")
+			}
+			if err := identity.Verify(cs.PeerCertificates[0]); err != nil {
+				return err
+			}
+			if verify != nil {
+				return verify(cs)
+			}
+			return nil
+		}
+	} else {
+		tlsConfig.VerifyConnection = verify
+	}
+	return tlsConfig, nil
+}
+
+// NewTLSClient sets up an *http.Client presenting a client certificate, see NewClientTLSConfig.
+// Its transport is otherwise a copy of http.DefaultTransport.
+func NewTLSClient(config TLSClientConfig) (*http.Client, error) {
+	tlsConfig, err := NewClientTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport, Timeout: config.Timeout}, nil
+}
@@ -0,0 +1,168 @@
+package certutils_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth/certutils"
+)
+
+func TestNewTLSClient(t *testing.T) {
+	dir := t.TempDir()
+	serverCert := filepath.Join(dir, "server.crt")
+	serverKey := filepath.Join(dir, "server.key")
+	clientCert := filepath.Join(dir, "client.crt")
+	clientKey := filepath.Join(dir, "client.key")
+	writeKeyPair(t, serverCert, serverKey, "server", time.Now().Add(time.Hour))
+	client := writeKeyPair(t, clientCert, clientKey, "client", time.Now().Add(time.Hour))
+
+	keypair, err := certutils.LoadKeyCertFiles(serverKey, serverCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(client)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{keypair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(config certutils.TLSClientConfig) (string, error) {
+		t.Helper()
+		c, err := certutils.NewTLSClient(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	config := certutils.TLSClientConfig{
+		CertFile:       clientCert,
+		KeyFile:        clientKey,
+		CAFile:         serverCert,
+		ServerName:     "server",
+		TLSConfigLevel: certutils.TLSConfigModern,
+	}
+	cn, err := get(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, cn, "client")
+
+	// the server identity is pinned
+	config.ServerIdentity = &certutils.ServerIdentity{DNSNames: []string{"server"}}
+	if _, err := get(config); err != nil {
+		t.Errorf("expected the pinned identity to match: %s", err)
+	}
+	config.ServerIdentity = &certutils.ServerIdentity{DNSNames: []string{"other"}}
+	if _, err := get(config); err == nil {
+		t.Error("expected a server not matching the pinned identity to be refused")
+	}
+
+	// the keypair is supplied by a Reloader
+	r, err := certutils.NewReloader(certutils.ReloaderConfig{CAFile: serverCert, CertFile: clientCert, KeyFile: clientKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn, err = get(certutils.TLSClientConfig{Reloader: r, ServerName: "server"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, cn, "client")
+
+	_, err = certutils.NewTLSClient(certutils.TLSClientConfig{CertFile: clientCert})
+	if err == nil {
+		t.Error("expected an error for a cert file without a key file")
+	}
+	_, err = certutils.NewTLSClient(certutils.TLSClientConfig{CAFile: filepath.Join(dir, "missing.crt")})
+	if err == nil {
+		t.Error("expected an error for a missing CA file")
+	}
+}
+
+func TestNewClientTLSConfigWithReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	writeKeyPair(t, certFile, keyFile, "client1", time.Now().Add(time.Hour))
+
+	r, err := certutils.NewReloader(certutils.ReloaderConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := certutils.NewClientTLSConfig(certutils.TLSClientConfig{Reloader: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, len(c.Certificates), 0)
+
+	// rotated certificates are presented without recreating the config
+	client2 := writeKeyPair(t, certFile, keyFile, "client2", time.Now().Add(time.Hour))
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := c.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Leaf.Equal(client2) {
+		t.Errorf("expected the rotated certificate, got %s", cert.Leaf.Subject)
+	}
+}
+
+func TestServerIdentity(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/api")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{OrganizationalUnit: []string{"endpoint"}},
+		DNSNames: []string{"api.example.org"},
+		URIs:     []*url.URL{spiffe},
+	}
+
+	tests := []struct {
+		Name     string
+		Identity certutils.ServerIdentity
+		Err      error
+	}{
+		{"Empty", certutils.ServerIdentity{}, nil},
+		{"All", certutils.ServerIdentity{
+			DNSNames:            []string{"other", "api.example.org"},
+			URIs:                []string{"spiffe://example.org/api"},
+			OrganizationalUnits: []string{"endpoint"},
+		}, nil},
+		{"DNSName", certutils.ServerIdentity{DNSNames: []string{"other"}},
+			errors.New("server certificate DNS names [api.example.org] do not match [other]")},
+		{"URI", certutils.ServerIdentity{URIs: []string{"spiffe://example.org/db"}},
+			errors.New("server certificate URIs [spiffe://example.org/api] do not match [spiffe://example.org/db]")},
+		{"OU", certutils.ServerIdentity{OrganizationalUnits: []string{"site"}},
+			errors.New("server certificate OUs [endpoint] do not match [site]")},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			err := tc.Identity.Verify(cert)
+			if (err == nil) != (tc.Err == nil) || (err != nil && err.Error() != tc.Err.Error()) {
+				t2.Errorf("expected error %v, got %v", tc.Err, err)
+			}
+		})
+	}
+}
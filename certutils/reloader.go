@@ -0,0 +1,300 @@
+//go:build go1.8
+// +build go1.8
+
+package certutils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth/internal/filewatch"
+)
+
+// ReloaderConfig is the configuration used to create a Reloader
+type ReloaderConfig struct {
+	// CAFile is the CA bundle used to verify client certificates. Optional.
+	CAFile string
+
+	// CertFile and KeyFile are the keypair presented by the server. Optional, but must be set
+	// together.
+	CertFile string
+	KeyFile  string
+
+	// Interval is how often the files are checked for changes. Defaults to 30 seconds.
+	Interval time.Duration
+
+	// OnError is called when changed files fail to load or validate. The previously loaded
+	// material stays in use.
+	OnError func(error)
+
+	// OnReload is called after new material has been swapped in
+	OnReload func()
+}
+
+// Reloader watches a CA bundle and keypair on disk and swaps them into a running TLS server
+// without a restart. New material is validated before it is used; if it fails to load the
+// previous material is kept and the error is reported to OnError.
+//
+// Files are polled and compared by their contents, so rotating a mounted secret is noticed.
+type Reloader struct {
+	cfg    ReloaderConfig
+	poller *filewatch.Poller
+
+	pool atomic.Pointer[x509.CertPool]
+	cert atomic.Pointer[tls.Certificate]
+	gen  atomic.Uint64
+}
+
+// NewReloader creates a Reloader and loads the configured files, returning an error if they are
+// not valid. Call Start to begin watching for changes.
+func NewReloader(cfg ReloaderConfig) (*Reloader, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" {
+		return nil, errors.New("reloader requires a CA file or a keypair")
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("reloader requires both a cert file and a key file")
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 30 * time.Second
+	}
+
+	var files []string
+	for _, name := range []string{cfg.CAFile, cfg.CertFile, cfg.KeyFile} {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	r := &Reloader{cfg: cfg}
+	r.poller = filewatch.New(files, cfg.Interval, r.apply)
+	if _, err := r.poller.Poll(true); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Start begins polling the files for changes in the background
+func (r *Reloader) Start() {
+	r.poller.Start(r.Reload, r.cfg.OnError)
+}
+
+// Stop stops polling the files. It is safe to call more than once.
+func (r *Reloader) Stop() {
+	r.poller.Stop()
+}
+
+// Reload checks the files for changes and swaps in any new material. It is called periodically
+// after Start, but may also be called directly, e.g. on SIGHUP.
+func (r *Reloader) Reload() error {
+	changed, err := r.poller.Poll(false)
+	if err == nil && changed && r.cfg.OnReload != nil {
+		r.cfg.OnReload()
+	}
+	return err
+}
+
+// apply validates the changed files and swaps them in
+func (r *Reloader) apply(files map[string][]byte) error {
+	// validate everything before swapping anything
+	var pool *x509.CertPool
+	if r.cfg.CAFile != "" {
+		pool = x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(files[r.cfg.CAFile]); !ok {
+			return fmt.Errorf("could not append CA Certificate from %s to CertPool", r.cfg.CAFile)
+		}
+	}
+	var cert *tls.Certificate
+	if r.cfg.CertFile != "" {
+		c, err := tls.X509KeyPair(files[r.cfg.CertFile], files[r.cfg.KeyFile])
+		if err != nil {
+			return fmt.Errorf("could not load TLS key pair: %s", err.Error())
+		}
+		if c.Leaf == nil {
+			if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+				return fmt.Errorf("could not parse certificate: %s", err.Error())
+			}
+		}
+		if now := time.Now(); now.After(c.Leaf.NotAfter) || now.Before(c.Leaf.NotBefore) {
+			return fmt.Errorf(
+				"certificate in %s is not valid between %s and %s",
+				r.cfg.CertFile, c.Leaf.NotBefore, c.Leaf.NotAfter,
+			)
+		}
+		cert = &c
+	}
+
+	if pool != nil {
+		r.pool.Store(pool)
+	}
+	if cert != nil {
+		r.cert.Store(cert)
+	}
+	r.gen.Add(1)
+	return nil
+}
+
+// CertPool returns the current CA pool
+func (r *Reloader) CertPool() *x509.CertPool {
+	return r.pool.Load()
+}
+
+// Certificate returns the current keypair
+func (r *Reloader) Certificate() *tls.Certificate {
+	return r.cert.Load()
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate to present the current keypair
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cert := r.cert.Load(); cert != nil {
+		return cert, nil
+	}
+	return nil, errors.New("reloader has no keypair configured")
+}
+
+// GetClientCertificate can be used as tls.Config.GetClientCertificate to present the current
+// keypair when acting as a client
+func (r *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if cert := r.cert.Load(); cert != nil {
+		return cert, nil
+	}
+	return nil, errors.New("reloader has no keypair configured")
+}
+
+// GetConfigForClient returns a function suitable for tls.Config.GetConfigForClient which serves
+// each handshake with a copy of `base` using the current CA pool as ClientCAs. The copy is only
+// rebuilt when the files change.
+//
+// The copy replaces the serving config entirely, so `base` must be complete. http.Server's
+// ServeTLS serves from its own copy of TLSConfig, adding the keypair files it is given and the
+// HTTP/2 ALPN protocols, neither of which `base` sees; NewTLSServer verifies client certificates
+// against the current pool instead.
+func (r *Reloader) GetConfigForClient(base *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	type snapshot struct {
+		gen    uint64
+		config *tls.Config
+	}
+	var current atomic.Pointer[snapshot]
+
+	return func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		gen := r.gen.Load()
+		if s := current.Load(); s != nil && s.gen == gen {
+			return s.config, nil
+		}
+		c := base.Clone()
+		c.GetConfigForClient = nil
+		if pool := r.pool.Load(); pool != nil {
+			c.ClientCAs = pool
+		}
+		if r.cert.Load() != nil {
+			c.Certificates = nil
+			c.GetCertificate = r.GetCertificate
+		}
+		current.Store(&snapshot{gen: gen, config: c})
+		return c, nil
+	}
+}
+
+// verifyClients makes `c` verify client certificates against the current CA pool. crypto/tls
+// only verifies against the fixed ClientCAs of the config it serves from, so the verification
+// moves to VerifyConnection, which runs for every handshake including resumed sessions. The
+// rest of the config is left alone so the keypair and ALPN protocols http.Server adds to its
+// copy still apply.
+func (r *Reloader) verifyClients(c *tls.Config) {
+	verifyPeer, verifyConn := c.VerifyPeerCertificate, c.VerifyConnection
+	c.ClientAuth = tls.RequireAnyClientCert
+	c.ClientCAs = nil
+	c.VerifyPeerCertificate = nil
+	c.VerifyConnection = func(cs tls.ConnectionState) error {
+		chains, err := r.verifyClient(cs.PeerCertificates)
+		if err != nil {
+			return err
+		}
+		cs.VerifiedChains = chains
+		if verifyPeer != nil {
+			rawCerts := make([][]byte, len(cs.PeerCertificates))
+			for i, cert := range cs.PeerCertificates {
+				rawCerts[i] = cert.Raw
+			}
+			if err := verifyPeer(rawCerts, chains); err != nil {
+				return err
+			}
+		}
+		if verifyConn != nil {
+			return verifyConn(cs)
+		}
+		return nil
+	}
+}
+
+// verifyClient verifies a client's certificates against the current CA pool the way crypto/tls
+// would, returning the verified chains
+func (r *Reloader) verifyClient(certs []*x509.Certificate) ([][]*x509.Certificate, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("tls: client didn't provide a certificate")
+	}
+	opts := x509.VerifyOptions{
+		Roots:         r.pool.Load(),
+		Intermediates: x509.NewCertPool(),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := certs[0].Verify(opts)
+	if err != nil {
+		return nil, &tls.CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
+	}
+	return chains, nil
+}
+
+type verifiedChainsKey struct{}
+
+// verifiedChains holds the chains of a connection's client certificate, verified once for all
+// of its requests
+type verifiedChains struct {
+	once   sync.Once
+	chains [][]*x509.Certificate
+}
+
+// connVerifiedChains is an http.Server ConnContext making room for the connection's chains
+func connVerifiedChains(ctx context.Context, _ net.Conn) context.Context {
+	return context.WithValue(ctx, verifiedChainsKey{}, &verifiedChains{})
+}
+
+// withVerifiedChains sets the request's TLS.VerifiedChains, which crypto/tls leaves empty for
+// servers using verifyClients, so certauth sees the chains it would have built. The chains are
+// verified again against the current pool; a connection whose CA was removed since its
+// handshake gets none.
+func (r *Reloader) withVerifiedChains(next http.Handler) http.Handler {
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.TLS == nil || len(req.TLS.VerifiedChains) > 0 || len(req.TLS.PeerCertificates) == 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+		v, ok := req.Context().Value(verifiedChainsKey{}).(*verifiedChains)
+		if !ok {
+			v = &verifiedChains{}
+		}
+		v.once.Do(func() {
+			v.chains, _ = r.verifyClient(req.TLS.PeerCertificates)
+		})
+		if v.chains != nil {
+			// req.TLS is shared by the requests of a connection, so set the chains on a copy
+			cs := *req.TLS
+			cs.VerifiedChains = v.chains
+			req = req.WithContext(req.Context())
+			req.TLS = &cs
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,266 @@
+package certutils_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth/certauthtest"
+	"github.com/pantheon-systems/go-certauth/certutils"
+)
+
+// writeKeyPair writes a self-signed certificate and its key as PEM files, returning the cert
+func writeKeyPair(t *testing.T, certFile, keyFile, cn string, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-2 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		DNSNames:              []string{cn},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	write(t, certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	write(t, keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func write(t *testing.T, name string, data []byte) {
+	t.Helper()
+	// write then rename so the reloader never sees a partial file
+	if err := os.WriteFile(name+".tmp", data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+
+	ca1 := writeKeyPair(t, caFile, filepath.Join(dir, "ca.key"), "ca1", time.Now().Add(time.Hour))
+	server1 := writeKeyPair(t, certFile, keyFile, "server1", time.Now().Add(time.Hour))
+
+	var reloads int
+	r, err := certutils.NewReloader(certutils.ReloaderConfig{
+		CAFile:   caFile,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		OnReload: func() { reloads++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := certutils.NewTLSConfig(certutils.TLSConfigModern)
+	base.ClientAuth = tls.RequireAndVerifyClientCert
+	getConfig := r.GetConfigForClient(base)
+
+	assertMaterial := func(ca, server *x509.Certificate) {
+		t.Helper()
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cert.Leaf.Equal(server) {
+			t.Errorf("expected server cert %s, got %s", server.Subject, cert.Leaf.Subject)
+		}
+		if _, err := ca.Verify(x509.VerifyOptions{Roots: r.CertPool()}); err != nil {
+			t.Errorf("expected %s to be in the CA pool: %s", ca.Subject, err)
+		}
+		c, _ := getConfig(nil)
+		if c.ClientAuth != tls.RequireAndVerifyClientCert || c.MinVersion != tls.VersionTLS12 {
+			t.Error("expected the handshake config to be based on the base config")
+		}
+		if !c.ClientCAs.Equal(r.CertPool()) {
+			t.Error("expected the handshake config to use the current CA pool")
+		}
+	}
+	assertMaterial(ca1, server1)
+
+	// nothing changed
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if reloads != 0 {
+		t.Errorf("expected no reloads, got %d", reloads)
+	}
+
+	// rotate both the CA and the keypair
+	ca2 := writeKeyPair(t, caFile, filepath.Join(dir, "ca.key"), "ca2", time.Now().Add(time.Hour))
+	server2 := writeKeyPair(t, certFile, keyFile, "server2", time.Now().Add(time.Hour))
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, reloads, 1)
+	assertMaterial(ca2, server2)
+
+	// a mismatched keypair is rejected and the previous material is kept
+	writeKeyPair(t, filepath.Join(dir, "other.crt"), keyFile, "other", time.Now().Add(time.Hour))
+	if err := r.Reload(); err == nil {
+		t.Error("expected an error for a mismatched keypair")
+	}
+	assertMaterial(ca2, server2)
+
+	// as is an expired certificate
+	writeKeyPair(t, certFile, keyFile, "expired", time.Now().Add(-time.Hour))
+	if err := r.Reload(); err == nil {
+		t.Error("expected an error for an expired certificate")
+	}
+	assertMaterial(ca2, server2)
+	expect(t, reloads, 1)
+
+	// the poller picks up changes and reports errors
+	errs := make(chan error, 1)
+	r, err = certutils.NewReloader(certutils.ReloaderConfig{
+		CAFile:   caFile,
+		Interval: 10 * time.Millisecond,
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Start()
+	defer r.Stop()
+	write(t, caFile, []byte("not a certificate"))
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the poller to report an error")
+	}
+}
+
+func TestNewTLSServerWithReloader(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	ca1, ca2 := certauthtest.NewCA(t), certauthtest.NewCA(t)
+	client1 := ca1.IssueClient(t, certauthtest.Options{CommonName: "client1"})
+	client2 := ca2.IssueClient(t, certauthtest.Options{CommonName: "client2"})
+	writeCA := func(ca *certauthtest.CA) {
+		write(t, caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate.Raw}))
+	}
+	writeCA(ca1)
+	serverCert := writeKeyPair(t, certFile, keyFile, "localhost", time.Now().Add(time.Hour))
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert)
+
+	// the reloader only supplies the CA pool; the keypair is passed to ServeTLS
+	r, err := certutils.NewReloader(certutils.ReloaderConfig{CAFile: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := certutils.NewTLSServer(certutils.TLSServerConfig{
+		Reloader: r,
+		Router: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s", r.Proto, r.TLS.VerifiedChains[0][0].Subject.CommonName)
+		}),
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			if len(cs.VerifiedChains) == 0 {
+				return errors.New("expected verified chains")
+			}
+			return nil
+		},
+	})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeTLS(ln, certFile, keyFile)
+	defer server.Close()
+
+	get := func(cert tls.Certificate) (string, error) {
+		t.Helper()
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      roots,
+			ServerName:   "localhost",
+		}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get("https://" + ln.Addr().String())
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	// HTTP/2 is negotiated and the handler sees the verified chains
+	body, err := get(client1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, body, "HTTP/2.0 client1")
+	if _, err := get(client2); err == nil {
+		t.Error("expected a client of an unknown CA to be rejected")
+	}
+
+	// rotating the CA applies to new connections
+	writeCA(ca2)
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	body, err = get(client2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect(t, body, "HTTP/2.0 client2")
+	if _, err := get(client1); err == nil {
+		t.Error("expected a client of the removed CA to be rejected")
+	}
+}
+
+func TestNewTLSServerVerifyConnection(t *testing.T) {
+	denied := errors.New("denied")
+	server := certutils.NewTLSServer(certutils.TLSServerConfig{
+		VerifyConnection: func(tls.ConnectionState) error { return denied },
+	})
+	if server.TLSConfig.VerifyConnection == nil {
+		t.Fatal("expected the server to use VerifyConnection")
+	}
+	expect(t, server.TLSConfig.VerifyConnection(tls.ConnectionState{}), denied)
+}
+
+func expect(t *testing.T, a interface{}, b interface{}) {
+	t.Helper()
+	if a != b {
+		t.Errorf("Expected [%v] (type %T) - Got [%v] (type %T)", b, b, a, a)
+	}
+}
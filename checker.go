@@ -0,0 +1,279 @@
+package certauth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// AuthRequest carries everything a RequestChecker may consider when authorizing a client.
+type AuthRequest struct {
+	// Identity is the client identity built by the configured IdentityExtractor
+	Identity *Identity
+
+	// Certificate is the verified leaf certificate presented by the client
+	Certificate *x509.Certificate
+
+	// VerifiedChains are the chains built by crypto/tls when verifying the client certificate.
+	// The first element of the first chain is Certificate.
+	VerifiedChains [][]*x509.Certificate
+
+	// Request is the HTTP request being authorized. It is nil when authorization is performed
+	// outside of an HTTP request, e.g. by calling Auth.CheckAuthorization directly.
+	Request *http.Request
+
+	// Params are the route parameters of the request, nil if it has none. See Params for the
+	// routers they may come from.
+	Params Params
+}
+
+// Decision is the outcome of a RequestChecker.
+type Decision struct {
+	// Allowed reports whether the client is authorized
+	Allowed bool
+
+	// Reason describes why the client was denied. It is used as the error message reported by
+	// the Auth.
+	Reason string
+
+	// Values are added to the request's context when the request is allowed, in the same way as
+	// the map returned by an AuthorizationChecker. Typed values can be added with Set.
+	Values Claims
+}
+
+// Allow returns a Decision allowing the request and adding `values` to its context
+func Allow(values map[ContextKey]ContextValue) Decision {
+	return Decision{Allowed: true, Values: values}
+}
+
+// Deny returns a Decision denying the request for the given reason
+func Deny(reason string) Decision {
+	return Decision{Reason: reason}
+}
+
+// RequestChecker is the second generation of AuthorizationChecker. Rather than just the client's
+// OUs and CN, it receives the full verified certificate chain, the HTTP request and route params
+// along with the request context.
+// Check returns a Decision describing whether the client is allowed. A non-nil error also denies
+// the request and is reported by the Auth in place of the Decision's Reason, which allows
+// checkers to surface typed errors.
+// Existing AuthorizationCheckers can be used wherever a RequestChecker is expected with
+// AdaptChecker.
+type RequestChecker interface {
+	Check(ctx context.Context, req *AuthRequest) (Decision, error)
+}
+
+// RequestCheckerFunc allows an ordinary function to be used as a RequestChecker
+type RequestCheckerFunc func(ctx context.Context, req *AuthRequest) (Decision, error)
+
+// Check calls f(ctx, req)
+func (f RequestCheckerFunc) Check(ctx context.Context, req *AuthRequest) (Decision, error) {
+	return f(ctx, req)
+}
+
+// WithRequestCheckers configures an Auth with a group of RequestCheckers. Groups are combined
+// with those added by WithCheckers: the Auth passes when all the checkers in any group pass.
+func WithRequestCheckers(checkers ...RequestChecker) AuthOption {
+	return func(a *Auth) {
+		a.checkers = append(a.checkers, checkers)
+	}
+}
+
+// SetCheckers atomically replaces all of the Auth's default checker groups, e.g. when reloading
+// a policy, and clears the decision cache. Routes configured with WithRoute are not affected.
+// It is safe to call while the Auth is serving requests; requests already being authorized
+// finish with the previous groups.
+func (a *Auth) SetCheckers(groups ...[]RequestChecker) {
+	a.mu.Lock()
+	a.checkers = groups
+	a.mu.Unlock()
+	// decisions made by the previous groups no longer apply
+	a.InvalidateCache()
+}
+
+// AdaptChecker wraps an AuthorizationChecker so it can be used as a RequestChecker.
+// The wrapped checker is called the same way the Auth has always called it: CheckIdentity if it
+// implements IdentityChecker, otherwise CheckAuthorizationWithParams when the request has route
+// params and CheckAuthorization when it does not.
+func AdaptChecker(ck AuthorizationChecker) RequestChecker {
+	return legacyChecker{ck}
+}
+
+func adaptCheckers(checkers []AuthorizationChecker) []RequestChecker {
+	adapted := make([]RequestChecker, 0, len(checkers))
+	for _, ck := range checkers {
+		adapted = append(adapted, AdaptChecker(ck))
+	}
+	return adapted
+}
+
+type legacyChecker struct {
+	AuthorizationChecker
+}
+
+// HeaderKeys implements HeaderKeyProvider for wrapped checkers which implement it
+func (l legacyChecker) HeaderKeys() []HeaderKey {
+	if p, ok := l.AuthorizationChecker.(HeaderKeyProvider); ok {
+		return p.HeaderKeys()
+	}
+	return nil
+}
+
+func (l legacyChecker) Check(ctx context.Context, req *AuthRequest) (Decision, error) {
+	var (
+		params map[ContextKey]ContextValue
+		err    error
+	)
+	if ick, ok := l.AuthorizationChecker.(IdentityChecker); ok { // wants the whole identity
+		params, err = ick.CheckIdentity(req.Identity, req.Params)
+	} else if req.Params == nil { // no route params
+		params, err = l.CheckAuthorization(req.Identity.OrganizationalUnits, req.Identity.CommonName)
+	} else { // route params, from any router
+		params, err = l.CheckAuthorizationWithParams(
+			req.Identity.OrganizationalUnits, req.Identity.CommonName, req.Params,
+		)
+	}
+	if err != nil {
+		return Decision{}, err
+	}
+	return Allow(params), nil
+}
+
+// Authorize runs the checker groups of the route matching req.Request, or the default groups,
+// against `req` and returns the collected context values of the first group to pass, or an error
+// if none of them pass.
+// req.Identity is built from req.Certificate with the configured IdentityExtractor if it is not
+// already set.
+func (a *Auth) Authorize(ctx context.Context, req *AuthRequest) (map[ContextKey]ContextValue, error) {
+	res := a.authorize(ctx, req)
+	return res.ctxParams, res.err
+}
+
+// evaluation is the detailed outcome of running the checker groups
+type evaluation struct {
+	ctxParams map[ContextKey]ContextValue
+	// pattern of the route whose groups were run, "" for the default groups
+	route string
+	// index of the group which passed, -1 if none did
+	group    int
+	failures []CheckerFailure
+	err      error
+	// set when the evaluation came from the decision cache
+	cached bool
+}
+
+func (a *Auth) authorize(ctx context.Context, req *AuthRequest) evaluation {
+	res := evaluation{group: -1}
+	if err := a.identify(req); err != nil {
+		res.err = err
+		return res
+	}
+
+	var gen uint64
+	if a.cache != nil {
+		// read before the groups, so a decision made by groups which have since been replaced is
+		// never cached
+		gen = a.cache.generation()
+	}
+	groups, scope, ps, err := a.routeGroups(req)
+	if err != nil {
+		res.err = err
+		return res
+	}
+	req.Params = normalizeParams(req.Params)
+	if req.Params == nil {
+		if len(ps) > 0 {
+			req.Params = ps
+		} else {
+			req.Params = PathValues(req.Request)
+		}
+	}
+
+	if a.cache == nil {
+		return a.runGroups(ctx, req, scope, groups)
+	}
+	key := a.cache.key(req, scope)
+	if key == "" {
+		return a.runGroups(ctx, req, scope, groups)
+	}
+	if cached, ok := a.cache.get(key); ok {
+		cached.cached = true
+		return cached
+	}
+	res = a.runGroups(ctx, req, scope, groups)
+	a.cache.put(key, gen, res)
+	return res
+}
+
+// identify extracts the client's identity from its certificate unless it is already set
+func (a *Auth) identify(req *AuthRequest) error {
+	if req.Identity == nil {
+		id, err := a.extractor(req.Certificate)
+		if err != nil {
+			return err
+		}
+		req.Identity = id
+	}
+	if req.Certificate == nil {
+		req.Certificate = req.Identity.Certificate
+	}
+	if req.Identity.VerifiedChains == nil {
+		req.Identity.VerifiedChains = req.VerifiedChains
+	}
+	return nil
+}
+
+// runGroups runs the checker groups in order until one passes. `scope` is the pattern of the
+// route the groups belong to, "" for the default groups.
+func (a *Auth) runGroups(
+	ctx context.Context, req *AuthRequest, scope string, groups [][]RequestChecker,
+) evaluation {
+	res := evaluation{route: scope, group: -1}
+	for i, cks := range groups { // trying all the groups of checkers
+		ctxParams, failure := a.runGroup(ctx, req, scope, i, cks)
+		// nil when a group passes, so we're done
+		if failure == nil {
+			res.ctxParams, res.group = ctxParams, i
+			return res
+		}
+		failure.Group = i
+		res.failures = append(res.failures, *failure)
+	}
+	if len(res.failures) > 0 {
+		res.err = &AuthorizationError{Failures: res.failures}
+		return res
+	}
+	// no checkers configured
+	res.ctxParams = map[ContextKey]ContextValue{}
+	return res
+}
+
+// runGroup runs each checker in a group, stopping at the first one which fails
+func (a *Auth) runGroup(
+	ctx context.Context, req *AuthRequest, scope string, group int, cks []RequestChecker,
+) (map[ContextKey]ContextValue, *CheckerFailure) {
+	ctxParams := make(map[ContextKey]ContextValue)
+	for i, ck := range cks {
+		start := time.Now()
+		d, err := ck.Check(ctx, req)
+		if a.metrics != nil {
+			a.metrics.ObserveChecker(scope, group, i, time.Since(start))
+		}
+		if err == nil && !d.Allowed {
+			err = errors.New(d.Reason)
+		}
+		if span := spanFromContext(ctx); span != nil {
+			span.CheckerResult(scope, group, i, err)
+		}
+		if err != nil {
+			return nil, &CheckerFailure{Checker: i, Err: err}
+		}
+		// Collect the context params from each checker into one map
+		for k, v := range d.Values {
+			ctxParams[k] = v
+		}
+	}
+	return ctxParams, nil
+}
@@ -0,0 +1,141 @@
+package certauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pantheon-systems/go-certauth"
+)
+
+type ctxKey string
+
+// readOnlyChecker allows GET requests from any client, and other methods only from clients
+// carrying the `X-Admin` header set by the test.
+var readOnlyChecker = certauth.RequestCheckerFunc(
+	func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+		if req.Request == nil {
+			return certauth.Decision{}, errors.New("not an HTTP request")
+		}
+		if req.Request.Method == http.MethodGet || req.Request.Header.Get("X-Admin") != "" {
+			return certauth.Allow(map[certauth.ContextKey]certauth.ContextValue{
+				ctxKey("path"):  req.Request.URL.Path,
+				ctxKey("cn"):    req.Certificate.Subject.CommonName,
+				ctxKey("chain"): len(req.VerifiedChains[0]),
+				ctxKey("trace"): ctx.Value(ctxKey("trace")),
+			}), nil
+		}
+		return certauth.Deny(fmt.Sprintf("%s is read only for %s", req.Request.URL.Path, req.Identity.CommonName)), nil
+	},
+)
+
+func TestRequestChecker(t *testing.T) {
+	auth := certauth.New(
+		certauth.WithRequestCheckers(
+			certauth.AdaptChecker(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+			readOnlyChecker,
+		),
+	)
+
+	var got context.Context
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Context()
+	}))
+
+	tests := []struct {
+		Name    string
+		Method  string
+		Admin   bool
+		OU      string
+		ExpCode int
+	}{
+		{"Get", http.MethodGet, false, "endpoint", http.StatusOK},
+		{"Post", http.MethodPost, false, "endpoint", http.StatusForbidden},
+		{"AdminPost", http.MethodPost, true, "endpoint", http.StatusOK},
+		{"WrongOU", http.MethodGet, false, "site", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			got = nil
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tc.Method, "https://foo.bar/foo", nil)
+			req = req.WithContext(context.WithValue(req.Context(), ctxKey("trace"), "abc"))
+			if tc.Admin {
+				req.Header.Set("X-Admin", "yes")
+			}
+			req.TLS = &tls.ConnectionState{}
+			req.TLS.VerifiedChains = fakeCertChain(
+				fakeCertData{[]string{tc.OU}, "foo.com"},
+				fakeCertData{nil, "intermediate"},
+			)
+
+			handler.ServeHTTP(w, req)
+			expect(t2, w.Code, tc.ExpCode)
+			if tc.ExpCode != http.StatusOK {
+				return
+			}
+			expect(t2, got.Value(ctxKey("path")), "/foo")
+			expect(t2, got.Value(ctxKey("cn")), "foo.com")
+			expect(t2, got.Value(ctxKey("chain")), 2)
+			expect(t2, got.Value(ctxKey("trace")), "abc")
+			// values from the adapted AuthorizationChecker are still added
+			expect(t2, fmt.Sprint(got.Value(certauth.HasAuthorizedOU)), "[endpoint]")
+		})
+	}
+}
+
+func TestRequestCheckerReason(t *testing.T) {
+	auth := certauth.New(certauth.WithRequestCheckers(readOnlyChecker))
+
+	req, _ := http.NewRequest(http.MethodDelete, "https://foo.bar/foo", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: fakeCertChain(fakeCertData{nil, "foo.com"})}
+	_, err := auth.Process(httptest.NewRecorder(), req)
+	expectErr(t, err, errors.New("/foo is read only for foo.com"))
+
+	// outside of an HTTP request the checker has no request to look at
+	_, err = auth.CheckAuthorization(req.TLS.VerifiedChains[0][0], nil)
+	expectErr(t, err, errors.New("not an HTTP request"))
+}
+
+func TestAdaptChecker(t *testing.T) {
+	check := certauth.AdaptChecker(certauth.AllowOUsandCNs([]string{"endpoint"}, []string{"foo.com"}))
+	cert := fakeCertChain(fakeCertData{[]string{"endpoint"}, "foo.com"})[0][0]
+	id, _ := certauth.ExtractIdentity(cert)
+
+	for _, ps := range []httprouter.Params{nil, {{Key: "site", Value: "foo"}}} {
+		d, err := check.Check(context.Background(), &certauth.AuthRequest{Identity: id, Params: ps})
+		expectErr(t, err, nil)
+		expect(t, d.Allowed, true)
+		expect(t, d.Values[certauth.HasAuthorizedCN], "foo.com")
+	}
+
+	id.CommonName = "bar.com"
+	d, err := check.Check(context.Background(), &certauth.AuthRequest{Identity: id})
+	expectErr(t, err, mkCNErr("bar.com", "foo.com"))
+	expect(t, d.Allowed, false)
+}
+
+func TestGroupsDoNotLeakContextValues(t *testing.T) {
+	// The first group sets HasAuthorizedOU before failing on the CN. Only the values of the
+	// group which passes should be returned.
+	auth := certauth.New(
+		certauth.WithCheckers(
+			certauth.AllowOUsandCNs([]string{"endpoint"}, nil),
+			certauth.AllowOUsandCNs(nil, []string{"not-foo.com"}),
+		),
+		certauth.WithCheckers(certauth.AllowOUsandCNs(nil, []string{"foo.com"})),
+	)
+
+	cert := fakeCertChain(fakeCertData{[]string{"endpoint"}, "foo.com"})[0][0]
+	params, err := auth.CheckAuthorization(cert, nil)
+	expectErr(t, err, nil)
+	if _, ok := params[certauth.HasAuthorizedOU]; ok {
+		t.Errorf("unexpected context value from a failed group: %v", params)
+	}
+	expect(t, params[certauth.HasAuthorizedCN], "foo.com")
+}
@@ -0,0 +1,45 @@
+// Package chiauth adapts a certauth.Auth as github.com/go-chi/chi middleware.
+//
+// chi only matches the route, and so knows its URL params, once the middleware added with
+// Router.Use has run. Add the middleware with Router.With, Router.Group or Router.Route for the
+// checkers to see the params of the route:
+//
+//	r := chi.NewRouter()
+//	r.With(chiauth.Middleware(auth)).Get("/sites/{site}", handler)
+package chiauth
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// Middleware returns chi middleware authorizing requests with `a`, passing the URL params of the
+// matched route to its checkers
+func Middleware(a *certauth.Auth) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var err error
+			if r, err = a.ProcessWithParams(w, r, Params(r)); err != nil {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Params returns the URL params chi captured for the request, or nil if it has none
+func Params(r *http.Request) httprouter.Params {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil || len(rctx.URLParams.Keys) == 0 {
+		return nil
+	}
+	ps := make(httprouter.Params, 0, len(rctx.URLParams.Keys))
+	for i, k := range rctx.URLParams.Keys {
+		ps = append(ps, httprouter.Param{Key: k, Value: rctx.URLParams.Values[i]})
+	}
+	return ps
+}
@@ -0,0 +1,11 @@
+module github.com/pantheon-systems/go-certauth/chiauth
+
+go 1.23
+
+require (
+	github.com/go-chi/chi/v5 v5.3.2
+	github.com/julienschmidt/httprouter v1.3.0
+	github.com/pantheon-systems/go-certauth v0.0.0-00010101000000-000000000000
+)
+
+replace github.com/pantheon-systems/go-certauth => ../
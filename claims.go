@@ -0,0 +1,98 @@
+package certauth
+
+import (
+	"context"
+	"fmt"
+)
+
+// Key is a typed key for a value attached to a request by a checker. Keys are compared by
+// identity, so keys created by different packages never collide even if they share a name.
+//
+//	var TenantKey = certauth.NewKey[Tenant]("tenant")
+//
+//	// in a checker
+//	claims := certauth.Claims{}
+//	certauth.Set(claims, TenantKey, tenant)
+//	return certauth.Allow(claims), nil
+//
+//	// in a handler
+//	tenant, ok := certauth.Get(r.Context(), TenantKey)
+type Key[T any] struct {
+	name string
+}
+
+// NewKey creates a Key for values of type T. `name` is only used to describe the key.
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+func (k *Key[T]) String() string {
+	return fmt.Sprintf("certauth key %s (%T)", k.name, *new(T))
+}
+
+// Claims are the values attached to an authorized request by its checkers. Values added with a
+// Key are typed; those returned by AuthorizationCheckers use their ContextKey.
+//
+// The Auth stores all of a request's Claims under a single context value rather than nesting a
+// context per value. The claims can still be read with ctx.Value(key), so code looking up
+// e.g. HasAuthorizedCN keeps working.
+type Claims map[ContextKey]ContextValue
+
+// Set adds a typed value to the claims
+func Set[T any](c Claims, k *Key[T], v T) {
+	c[k] = v
+}
+
+// GetClaim returns a typed value from the claims
+func GetClaim[T any](c Claims, k *Key[T]) (T, bool) {
+	v, ok := c[k].(T)
+	return v, ok
+}
+
+// Get returns a typed value attached to the request by its checkers
+func Get[T any](ctx context.Context, k *Key[T]) (T, bool) {
+	c, _ := ClaimsFromContext(ctx)
+	return GetClaim(c, k)
+}
+
+// ClaimsFromContext returns all the values attached to the request by its checkers
+func ClaimsFromContext(ctx context.Context) (Claims, bool) {
+	c, ok := ctx.Value(claimsKey).(Claims)
+	return c, ok
+}
+
+// NewContext returns a context holding the identity and claims of an authorized client, as the
+// Auth attaches them to authorized requests. It is used by integrations authorizing clients
+// outside of net/http, e.g. gRPC, with Authorize.
+func NewContext(ctx context.Context, id *Identity, claims Claims) context.Context {
+	return withClaims(ctx, id, claims)
+}
+
+const claimsKey = contextKey("Claims")
+
+// claimsContext holds the identity and claims of an authorized request in a single context
+type claimsContext struct {
+	context.Context
+	id     *Identity
+	claims Claims
+}
+
+func withClaims(ctx context.Context, id *Identity, claims Claims) context.Context {
+	if claims == nil {
+		claims = Claims{}
+	}
+	return &claimsContext{Context: ctx, id: id, claims: claims}
+}
+
+func (c *claimsContext) Value(key interface{}) interface{} {
+	switch key {
+	case IdentityKey:
+		return c.id
+	case claimsKey:
+		return c.claims
+	}
+	if v, ok := c.claims[key]; ok {
+		return v
+	}
+	return c.Context.Value(key)
+}
@@ -0,0 +1,73 @@
+package certauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+type tenant struct {
+	Name string
+	Tier int
+}
+
+var (
+	tenantKey = certauth.NewKey[tenant]("tenant")
+	// same name and type, but a different key
+	otherTenantKey = certauth.NewKey[tenant]("tenant")
+	tierKey        = certauth.NewKey[int]("tier")
+)
+
+func TestClaims(t *testing.T) {
+	tenantChecker := certauth.RequestCheckerFunc(
+		func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+			claims := certauth.Claims{}
+			certauth.Set(claims, tenantKey, tenant{Name: req.Identity.CommonName, Tier: 2})
+			certauth.Set(claims, tierKey, 2)
+			return certauth.Allow(claims), nil
+		},
+	)
+	auth := certauth.New(certauth.WithRequestCheckers(
+		certauth.AdaptChecker(certauth.AllowOUsandCNs(nil, []string{"foo.com"})),
+		tenantChecker,
+	))
+
+	var ctx context.Context
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	}))
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "https://foo.bar/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), ctxKey("trace"), "abc"))
+	req.TLS = &tls.ConnectionState{VerifiedChains: fakeCertChain(fakeCertData{cn: "foo.com"})}
+	handler.ServeHTTP(w, req)
+	expect(t, w.Code, http.StatusOK)
+
+	got, ok := certauth.Get(ctx, tenantKey)
+	expect(t, ok, true)
+	expect(t, got, tenant{Name: "foo.com", Tier: 2})
+	tier, _ := certauth.Get(ctx, tierKey)
+	expect(t, tier, 2)
+	_, ok = certauth.Get(ctx, otherTenantKey)
+	expect(t, ok, false)
+
+	// values from AuthorizationCheckers and the parent context are still reachable
+	expect(t, ctx.Value(certauth.HasAuthorizedCN), "foo.com")
+	expect(t, ctx.Value(ctxKey("trace")), "abc")
+	id, _ := certauth.IdentityFromContext(ctx)
+	expect(t, id.CommonName, "foo.com")
+
+	claims, ok := certauth.ClaimsFromContext(ctx)
+	expect(t, ok, true)
+	expect(t, len(claims), 3)
+	got, _ = certauth.GetClaim(claims, tenantKey)
+	expect(t, got.Name, "foo.com")
+
+	// nothing is found outside an authorized request
+	_, ok = certauth.Get(context.Background(), tenantKey)
+	expect(t, ok, false)
+}
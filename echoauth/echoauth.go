@@ -0,0 +1,47 @@
+// Package echoauth adapts a certauth.Auth as github.com/labstack/echo middleware.
+//
+//	e := echo.New()
+//	e.Use(echoauth.Middleware(auth))
+//	e.GET("/sites/:site", handler)
+package echoauth
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/labstack/echo/v4"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// Middleware returns echo middleware authorizing requests with `a`, passing the path params of
+// the matched route to its checkers. Rejected requests are answered by the Auth's error handler
+// and the middleware returns nil, so echo's HTTPErrorHandler is not involved.
+//
+// Add it with Echo.Use or to a route or group: echo matches the route before running those. It
+// does not see the path params when added with Echo.Pre.
+func Middleware(a *certauth.Auth) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			r, err := a.ProcessWithParams(c.Response(), c.Request(), Params(c))
+			if err != nil {
+				return nil
+			}
+			c.SetRequest(r)
+			return next(c)
+		}
+	}
+}
+
+// Params returns the path params echo captured for the request, or nil if it has none
+func Params(c echo.Context) httprouter.Params {
+	names, values := c.ParamNames(), c.ParamValues()
+	if len(names) == 0 {
+		return nil
+	}
+	ps := make(httprouter.Params, 0, len(names))
+	for i, name := range names {
+		if i < len(values) {
+			ps = append(ps, httprouter.Param{Key: name, Value: values[i]})
+		}
+	}
+	return ps
+}
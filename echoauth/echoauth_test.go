@@ -0,0 +1,66 @@
+package echoauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/echoauth"
+)
+
+func expect(t *testing.T, actual interface{}, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Expected [%v] (type %T) - Got [%v] (type %T)", expected, expected, actual, actual)
+	}
+}
+
+// siteChecker allows clients whose CN is the `site` param
+var siteChecker = certauth.RequestCheckerFunc(
+	func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+		if req.Params.ByName("site") != req.Identity.CommonName {
+			return certauth.Deny("wrong site"), nil
+		}
+		return certauth.Allow(nil), nil
+	},
+)
+
+func TestMiddleware(t *testing.T) {
+	auth := certauth.New(certauth.WithRequestCheckers(siteChecker))
+	r := echo.New()
+	r.Use(echoauth.Middleware(auth))
+	r.GET("/sites/:site", func(c echo.Context) error {
+		id, _ := certauth.IdentityFromContext(c.Request().Context())
+		return c.String(http.StatusOK, id.CommonName)
+	})
+
+	tests := []struct {
+		Name    string
+		Path    string
+		ExpCode int
+	}{
+		{"Allowed", "/sites/foo", http.StatusOK},
+		{"WrongSite", "/sites/bar", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "https://foo.bar"+tc.Path, nil)
+			req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{
+				{Subject: pkix.Name{CommonName: "foo"}},
+			}}}
+			r.ServeHTTP(w, req)
+			expect(t2, w.Code, tc.ExpCode)
+			if tc.ExpCode == http.StatusOK {
+				expect(t2, w.Body.String(), "foo")
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package certauth
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrNoClientCert is returned when the request has no verified client certificate chain
+	ErrNoClientCert = errors.New("no cert chain detected")
+
+	// ErrChainMismatch is returned when the first peer certificate presented by the client is not
+	// the leaf of the first verified chain
+	ErrChainMismatch = errors.New("first peer certificate not first verified chain leaf")
+
+	// ErrNoServerCert is returned by the function built with Auth.VerifyServer when the server
+	// being called has no verified certificate chain
+	ErrNoServerCert = errors.New("no server cert chain detected")
+)
+
+// Reasons used to classify why a request was denied, e.g. as a metrics label. See ReasonFor.
+const (
+	ReasonNoClientCert   = "no_cert"
+	ReasonNoServerCert   = "no_server_cert"
+	ReasonChainMismatch  = "chain_mismatch"
+	ReasonNoRoute        = "no_route"
+	ReasonAmbiguousRoute = "ambiguous_route"
+	ReasonOUMismatch     = "ou_mismatch"
+	ReasonCNMismatch     = "cn_mismatch"
+	// ReasonDenied is used for denials which aren't otherwise classified
+	ReasonDenied = "denied"
+)
+
+// ReasonError is an error classified with a short, fixed reason such as ReasonOUMismatch.
+// Checkers may return one, or wrap one, so their denials are classified by ReasonFor. Its
+// message is that of Err.
+type ReasonError struct {
+	Reason string
+	Err    error
+}
+
+func (e *ReasonError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ReasonError) Unwrap() error {
+	return e.Err
+}
+
+// ReasonFor classifies the error which caused a request to be denied. The reason of an
+// AuthorizationError is that of its last failure, matching its message.
+// It returns "" for a nil error.
+func ReasonFor(err error) string {
+	var authErr *AuthorizationError
+	if errors.As(err, &authErr) && len(authErr.Failures) > 0 {
+		err = authErr.Failures[len(authErr.Failures)-1].Err
+	}
+	var reasonErr *ReasonError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrNoClientCert):
+		return ReasonNoClientCert
+	case errors.Is(err, ErrNoServerCert):
+		return ReasonNoServerCert
+	case errors.Is(err, ErrChainMismatch):
+		return ReasonChainMismatch
+	case errors.Is(err, ErrNoRoute):
+		return ReasonNoRoute
+	case errors.Is(err, ErrAmbiguousRoute):
+		return ReasonAmbiguousRoute
+	case errors.As(err, &reasonErr):
+		return reasonErr.Reason
+	}
+	return ReasonDenied
+}
+
+// AuthErrorKey is used as the request context key holding the error which caused a request to be
+// rejected. It is set on the request passed to the error handler; see ErrorFromContext.
+const AuthErrorKey = contextKey("Auth Error")
+
+// CheckerFailure describes a checker which denied a request
+type CheckerFailure struct {
+	// Group is the index of the checker group, in the order the groups were configured
+	Group int
+	// Checker is the index of the failing checker within its group
+	Checker int
+	// Err is the error returned by the checker, or built from its Decision's Reason
+	Err error
+}
+
+// AuthorizationError is returned when none of the configured checker groups authorize the
+// client. It records the failing checker of every group that was tried.
+// Errors returned by the checkers can be matched using errors.Is and errors.As on the
+// AuthorizationError.
+type AuthorizationError struct {
+	Failures []CheckerFailure
+}
+
+// Error returns the message of the last failing checker, which matches the error the Auth has
+// always reported.
+func (e *AuthorizationError) Error() string {
+	if len(e.Failures) == 0 {
+		return "not authorized"
+	}
+	return e.Failures[len(e.Failures)-1].Err.Error()
+}
+
+// Unwrap returns the errors of every failing checker
+func (e *AuthorizationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		errs = append(errs, f.Err)
+	}
+	return errs
+}
+
+// ErrorFromContext returns the error which caused the request to be rejected, or nil. It is
+// available to the error handler configured with WithErrorHandler.
+func ErrorFromContext(ctx context.Context) error {
+	err, _ := ctx.Value(AuthErrorKey).(error)
+	return err
+}
@@ -0,0 +1,101 @@
+package certauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+var errRevoked = errors.New("certificate revoked")
+
+func TestErrorHandlerReceivesError(t *testing.T) {
+	var handled error
+	auth := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		certauth.WithCheckers(certauth.AllowOUsandCNs(nil, []string{"admin"})),
+		certauth.WithErrorHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handled = certauth.ErrorFromContext(r.Context())
+			http.Error(w, "nope", http.StatusUnauthorized)
+		})),
+	)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not be called")
+	})
+
+	other := fakeCertChain(fakeCertData{[]string{"site"}, "other.com"})[0][0]
+	tests := []struct {
+		Name   string
+		TLS    *tls.ConnectionState
+		ExpErr error
+	}{
+		{"NoTLS", nil, certauth.ErrNoClientCert},
+		{"NoChains", &tls.ConnectionState{}, certauth.ErrNoClientCert},
+		{"EmptyChain", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}, certauth.ErrNoClientCert},
+		{
+			"ChainMismatch",
+			&tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{{Raw: []byte("a")}},
+				VerifiedChains:   [][]*x509.Certificate{{{Raw: []byte("b")}}},
+			},
+			certauth.ErrChainMismatch,
+		},
+		{
+			"Unauthorized",
+			&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{other}}},
+			mkCNErr("other.com", "admin"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			handled = nil
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "https://foo.bar/foo", nil)
+			req.TLS = tc.TLS
+
+			auth.Handler(next).ServeHTTP(w, req)
+			expect(t2, w.Code, http.StatusUnauthorized)
+			expectErr(t2, handled, tc.ExpErr)
+		})
+	}
+}
+
+func TestAuthorizationError(t *testing.T) {
+	revoked := certauth.RequestCheckerFunc(
+		func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+			return certauth.Decision{}, errRevoked
+		},
+	)
+	auth := certauth.New(
+		certauth.WithCheckers(
+			certauth.AllowOUsandCNs([]string{"endpoint"}, nil),
+			certauth.AllowOUsandCNs(nil, []string{"admin"}),
+		),
+		certauth.WithRequestCheckers(revoked),
+	)
+
+	cert := fakeCertChain(fakeCertData{[]string{"endpoint"}, "foo.com"})[0][0]
+	_, err := auth.CheckAuthorization(cert, nil)
+	expectErr(t, err, errRevoked)
+
+	if !errors.Is(err, errRevoked) {
+		t.Errorf("expected errors.Is(%v, errRevoked)", err)
+	}
+	var authErr *certauth.AuthorizationError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected an AuthorizationError, got %T", err)
+	}
+	expect(t, len(authErr.Failures), 2)
+	expect(t, authErr.Failures[0].Group, 0)
+	expect(t, authErr.Failures[0].Checker, 1)
+	expectErr(t, authErr.Failures[0].Err, mkCNErr("foo.com", "admin"))
+	expect(t, authErr.Failures[1].Group, 1)
+	expect(t, authErr.Failures[1].Checker, 0)
+	expect(t, authErr.Failures[1].Err, errRevoked)
+}
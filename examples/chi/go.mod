@@ -0,0 +1,16 @@
+module github.com/pantheon-systems/go-certauth/examples/chi
+
+go 1.23
+
+require (
+	github.com/go-chi/chi/v5 v5.3.2
+	github.com/pantheon-systems/go-certauth v0.0.0-00010101000000-000000000000
+	github.com/pantheon-systems/go-certauth/chiauth v0.0.0-00010101000000-000000000000
+)
+
+require github.com/julienschmidt/httprouter v1.3.0 // indirect
+
+replace (
+	github.com/pantheon-systems/go-certauth => ../../
+	github.com/pantheon-systems/go-certauth/chiauth => ../../chiauth
+)
@@ -0,0 +1,66 @@
+package main
+
+/*
+Example using github.com/go-chi/chi:
+
+	$ go run main.go &
+
+	$ curl -kE ../test-fixtures/client1.pem https://localhost:18080/
+	Welcome!
+
+	$ curl -kE ../test-fixtures/client1.pem https://localhost:18080/hello/foo
+	hello, foo!
+
+	$ curl -kE ../test-fixtures/client2.pem https://localhost:18080/hello/foo
+	Authentication Failed
+
+	### NOTE: curl on macOS might require using the .p12 file instead of the .pem:
+	$ curl -kE ../test-fixtures/client1.p12:password https://localhost:18080/
+*/
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/certutils"
+	"github.com/pantheon-systems/go-certauth/chiauth"
+)
+
+func Index(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "Welcome!\n")
+}
+
+func Hello(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintf(w, "hello, %s!\n", chi.URLParam(r, "name"))
+}
+
+func main() {
+	caCerts, err := certutils.LoadCACertFile("../test-fixtures/ca.crt")
+	if err != nil {
+		log.Fatalf("Unable to load ca.crt: %s", err)
+	}
+
+	auth := certauth.New(certauth.WithCheckers(
+		certauth.AllowOUsandCNs([]string{"endpoint"}, []string{"client1"}),
+	))
+
+	router := chi.NewRouter()
+	router.Get("/", Index)
+	// middleware added with With or Group runs once chi has matched the route, so the checkers
+	// see its URL params
+	router.With(chiauth.Middleware(auth)).Get("/hello/{name}", Hello)
+
+	cfg := certutils.TLSServerConfig{
+		CertPool:    caCerts,
+		BindAddress: "",
+		Port:        18080,
+		Router:      router,
+	}
+
+	server := certutils.NewTLSServer(cfg)
+	server.ListenAndServeTLS("../test-fixtures/server.pem", "../test-fixtures/server.pem")
+}
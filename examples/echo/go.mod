@@ -0,0 +1,27 @@
+module github.com/pantheon-systems/go-certauth/examples/echo
+
+go 1.24.0
+
+require (
+	github.com/labstack/echo/v4 v4.15.1
+	github.com/pantheon-systems/go-certauth v0.0.0-00010101000000-000000000000
+	github.com/pantheon-systems/go-certauth/echoauth v0.0.0-00010101000000-000000000000
+)
+
+require (
+	github.com/julienschmidt/httprouter v1.3.0 // indirect
+	github.com/labstack/gommon v0.4.2 // indirect
+	github.com/mattn/go-colorable v0.1.14 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/valyala/fasttemplate v1.2.2 // indirect
+	golang.org/x/crypto v0.47.0 // indirect
+	golang.org/x/net v0.48.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
+	golang.org/x/text v0.33.0 // indirect
+)
+
+replace (
+	github.com/pantheon-systems/go-certauth => ../../
+	github.com/pantheon-systems/go-certauth/echoauth => ../../echoauth
+)
@@ -0,0 +1,56 @@
+package main
+
+/*
+Example using github.com/labstack/echo:
+
+	$ go run main.go &
+
+	$ curl -kE ../test-fixtures/client1.pem https://localhost:18080/hello/foo
+	hello, foo!
+
+	$ curl -kE ../test-fixtures/client2.pem https://localhost:18080/hello/foo
+	Authentication Failed
+
+	### NOTE: curl on macOS might require using the .p12 file instead of the .pem:
+	$ curl -kE ../test-fixtures/client1.p12:password https://localhost:18080/hello/foo
+*/
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/certutils"
+	"github.com/pantheon-systems/go-certauth/echoauth"
+)
+
+func Hello(c echo.Context) error {
+	return c.String(http.StatusOK, "hello, "+c.Param("name")+"!\n")
+}
+
+func main() {
+	caCerts, err := certutils.LoadCACertFile("../test-fixtures/ca.crt")
+	if err != nil {
+		log.Fatalf("Unable to load ca.crt: %s", err)
+	}
+
+	auth := certauth.New(certauth.WithCheckers(
+		certauth.AllowOUsandCNs([]string{"endpoint"}, []string{"client1"}),
+	))
+
+	e := echo.New()
+	e.Use(echoauth.Middleware(auth))
+	e.GET("/hello/:name", Hello)
+
+	cfg := certutils.TLSServerConfig{
+		CertPool:    caCerts,
+		BindAddress: "",
+		Port:        18080,
+		Router:      e,
+	}
+
+	server := certutils.NewTLSServer(cfg)
+	server.ListenAndServeTLS("../test-fixtures/server.pem", "../test-fixtures/server.pem")
+}
@@ -0,0 +1,16 @@
+module github.com/pantheon-systems/go-certauth/examples/negroni
+
+go 1.21
+
+require (
+	github.com/pantheon-systems/go-certauth v0.0.0-00010101000000-000000000000
+	github.com/pantheon-systems/go-certauth/negroniauth v0.0.0-00010101000000-000000000000
+	github.com/urfave/negroni v1.0.0
+)
+
+require github.com/julienschmidt/httprouter v1.3.0 // indirect
+
+replace (
+	github.com/pantheon-systems/go-certauth => ../../
+	github.com/pantheon-systems/go-certauth/negroniauth => ../../negroniauth
+)
@@ -0,0 +1,60 @@
+package main
+
+/*
+Example using github.com/urfave/negroni:
+
+	$ go run main.go &
+
+	$ curl -kE ../test-fixtures/client1.pem https://localhost:18080/
+	hello, world!
+
+	$ curl -kE ../test-fixtures/client2.pem https://localhost:18080/
+	Authentication Failed
+
+	### NOTE: curl on macOS might require using the .p12 file instead of the .pem:
+	$ curl -kE ../test-fixtures/client1.p12:password https://localhost:18080/
+*/
+
+import (
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/urfave/negroni"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/certutils"
+	"github.com/pantheon-systems/go-certauth/negroniauth"
+)
+
+func HelloServer(w http.ResponseWriter, req *http.Request) {
+	io.WriteString(w, "hello, world!\n")
+}
+
+func main() {
+	caCerts, err := certutils.LoadCACertFile("../test-fixtures/ca.crt")
+	if err != nil {
+		log.Fatalf("Unable to load ca.crt: %s", err)
+	}
+
+	auth := certauth.New(certauth.WithCheckers(
+		certauth.AllowOUsandCNs([]string{"endpoint"}, []string{"client1"}),
+	))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", HelloServer)
+
+	n := negroni.Classic()
+	n.Use(negroniauth.Middleware(auth))
+	n.UseHandler(mux)
+
+	cfg := certutils.TLSServerConfig{
+		CertPool:    caCerts,
+		BindAddress: "",
+		Port:        18080,
+		Router:      n,
+	}
+
+	server := certutils.NewTLSServer(cfg)
+	server.ListenAndServeTLS("../test-fixtures/server.pem", "../test-fixtures/server.pem")
+}
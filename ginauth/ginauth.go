@@ -0,0 +1,40 @@
+// Package ginauth adapts a certauth.Auth as github.com/gin-gonic/gin middleware.
+//
+//	r := gin.New()
+//	r.Use(ginauth.Middleware(auth))
+//	r.GET("/sites/:site", handler)
+package ginauth
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// Middleware returns gin middleware authorizing requests with `a`, passing the path params of the
+// matched route to its checkers. Rejected requests are answered by the Auth's error handler and
+// the rest of the chain is aborted.
+func Middleware(a *certauth.Auth) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r, err := a.ProcessWithParams(c.Writer, c.Request, Params(c))
+		if err != nil {
+			c.Abort()
+			return
+		}
+		c.Request = r
+		c.Next()
+	}
+}
+
+// Params returns the path params gin captured for the request, or nil if it has none
+func Params(c *gin.Context) httprouter.Params {
+	if len(c.Params) == 0 {
+		return nil
+	}
+	ps := make(httprouter.Params, 0, len(c.Params))
+	for _, p := range c.Params {
+		ps = append(ps, httprouter.Param{Key: p.Key, Value: p.Value})
+	}
+	return ps
+}
@@ -0,0 +1,19 @@
+module github.com/pantheon-systems/go-certauth/grpcauth
+
+go 1.24.0
+
+require (
+	github.com/pantheon-systems/go-certauth v0.0.0-00010101000000-000000000000
+	google.golang.org/grpc v1.79.0
+)
+
+require (
+	github.com/julienschmidt/httprouter v1.3.0 // indirect
+	golang.org/x/net v0.48.0 // indirect
+	golang.org/x/sys v0.41.0 // indirect
+	golang.org/x/text v0.33.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20251202230838-ff82c1b0f217 // indirect
+	google.golang.org/protobuf v1.36.10 // indirect
+)
+
+replace github.com/pantheon-systems/go-certauth => ../
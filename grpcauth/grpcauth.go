@@ -0,0 +1,137 @@
+// Package grpcauth authorizes gRPC calls with a certauth.Auth, using unary and stream server
+// interceptors.
+//
+//	auth := certauth.New(
+//		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+//		certauth.WithRouteCheckers("/admin.v1.Admin/", certauth.AllowOUsandCNs(nil, []string{"admin"})),
+//	)
+//	srv := grpc.NewServer(
+//		grpc.Creds(credentials.NewTLS(tlsConfig)),
+//		grpc.UnaryInterceptor(grpcauth.UnaryServerInterceptor(auth)),
+//		grpc.StreamInterceptor(grpcauth.StreamServerInterceptor(auth)),
+//	)
+//
+// Each call is authorized as an HTTP/2 `POST` request for its full method name, e.g.
+// `/admin.v1.Admin/DeleteSite`, so rules for a service or method are routes configured with
+// certauth.WithRoute: `/admin.v1.Admin/` matches every method of the service and
+// `/admin.v1.Admin/DeleteSite` a single method. The incoming metadata is passed to the checkers
+// as the request's headers.
+//
+// Calls from clients without a verified certificate fail with codes.Unauthenticated and calls
+// the checkers deny fail with codes.PermissionDenied. Every call is reported to the Auth's
+// AuditSink, Metrics and Tracer, see certauth.Auth.AuthorizeRequest. Authorized calls carry the client's
+// identity and claims in their context, see certauth.IdentityFromContext.
+package grpcauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// UnaryServerInterceptor returns a grpc.UnaryServerInterceptor authorizing calls with `a`
+func UnaryServerInterceptor(a *certauth.Auth) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, err := Authorize(ctx, a, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor returns a grpc.StreamServerInterceptor authorizing calls with `a`
+func StreamServerInterceptor(a *certauth.Auth) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler,
+	) error {
+		ctx, err := Authorize(ss.Context(), a, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authorizedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authorizedStream replaces the context of a stream with the authorized one
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
+
+// Authorize authorizes a call to `fullMethod` with `a`, returning the context to handle it with or
+// a gRPC status error. The interceptors call it for every call; it is exported for servers which
+// chain their interceptors differently.
+func Authorize(ctx context.Context, a *certauth.Auth, fullMethod string) (context.Context, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "no peer found")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "connection is not using TLS")
+	}
+
+	r := callRequest(ctx, fullMethod)
+	if p.Addr != nil {
+		r.RemoteAddr = p.Addr.String()
+	}
+	r.TLS = &tlsInfo.State
+	id, claims, err := a.AuthorizeRequest(r, nil)
+	if err != nil {
+		var authErr *certauth.AuthorizationError
+		if errors.As(err, &authErr) || errors.Is(err, certauth.ErrNoRoute) ||
+			errors.Is(err, certauth.ErrAmbiguousRoute) {
+			return nil, status.Error(codes.PermissionDenied, "Authentication Failed")
+		}
+		// e.g. no verified client certificate, or the identity could not be extracted from it
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return certauth.NewContext(ctx, id, claims), nil
+}
+
+// callRequest describes a call as the HTTP/2 request carrying it
+func callRequest(ctx context.Context, fullMethod string) *http.Request {
+	r := &http.Request{
+		Method:     http.MethodPost,
+		URL:        &url.URL{Path: fullMethod},
+		RequestURI: fullMethod,
+		Proto:      "HTTP/2.0",
+		ProtoMajor: 2,
+		Header:     make(http.Header),
+	}
+	r = r.WithContext(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, vs := range md {
+		if k == ":authority" {
+			if len(vs) > 0 {
+				r.Host = vs[0]
+			}
+			continue
+		}
+		if strings.HasPrefix(k, ":") || strings.HasSuffix(k, "-bin") {
+			continue
+		}
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	return r
+}
@@ -0,0 +1,187 @@
+package grpcauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/grpcauth"
+)
+
+func expect(t *testing.T, actual interface{}, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Expected [%v] (type %T) - Got [%v] (type %T)", expected, expected, actual, actual)
+	}
+}
+
+func peerContext(authInfo credentials.AuthInfo) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234},
+		AuthInfo: authInfo,
+	})
+}
+
+func clientInfo(ou, cn string) credentials.TLSInfo {
+	cert := &x509.Certificate{
+		Raw:     []byte(cn),
+		Subject: pkix.Name{OrganizationalUnit: []string{ou}, CommonName: cn},
+	}
+	return credentials.TLSInfo{State: tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	auth := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		// routes are tried in order: Ping is open to any client, the rest of the service to admin
+		certauth.WithRouteCheckers("/admin.v1.Admin/Ping"),
+		certauth.WithRouteCheckers("/admin.v1.Admin/", certauth.AllowOUsandCNs(nil, []string{"admin"})),
+	)
+	interceptor := grpcauth.UnaryServerInterceptor(auth)
+
+	mismatch := clientInfo("endpoint", "foo.com")
+	mismatch.State.PeerCertificates = []*x509.Certificate{{Raw: []byte("other")}}
+
+	tests := []struct {
+		Name    string
+		Ctx     context.Context
+		Method  string
+		ExpCode codes.Code
+		ExpCN   string
+	}{
+		{"Allowed", peerContext(clientInfo("endpoint", "foo.com")), "/foo.v1.Foo/Get", codes.OK, "foo.com"},
+		{"WrongOU", peerContext(clientInfo("site", "foo.com")), "/foo.v1.Foo/Get", codes.PermissionDenied, ""},
+		{"ServiceRule", peerContext(clientInfo("endpoint", "foo.com")), "/admin.v1.Admin/Delete", codes.PermissionDenied, ""},
+		{"ServiceRuleAllowed", peerContext(clientInfo("site", "admin")), "/admin.v1.Admin/Delete", codes.OK, "admin"},
+		{"MethodRule", peerContext(clientInfo("site", "foo.com")), "/admin.v1.Admin/Ping", codes.OK, "foo.com"},
+		{"NoPeer", context.Background(), "/foo.v1.Foo/Get", codes.Unauthenticated, ""},
+		{"NoTLS", peerContext(nil), "/foo.v1.Foo/Get", codes.Unauthenticated, ""},
+		{"NoCert", peerContext(credentials.TLSInfo{}), "/foo.v1.Foo/Get", codes.Unauthenticated, ""},
+		{"ChainMismatch", peerContext(mismatch), "/foo.v1.Foo/Get", codes.Unauthenticated, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			var cn string
+			_, err := interceptor(tc.Ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.Method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					id, _ := certauth.IdentityFromContext(ctx)
+					cn = id.CommonName
+					return nil, nil
+				},
+			)
+			expect(t2, status.Code(err), tc.ExpCode)
+			expect(t2, cn, tc.ExpCN)
+		})
+	}
+}
+
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	// checkers see the call's metadata as headers
+	auth := certauth.New(certauth.WithRequestCheckers(certauth.RequestCheckerFunc(
+		func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+			if req.Request.Header.Get("X-Tenant") != req.Identity.CommonName {
+				return certauth.Deny("wrong tenant"), nil
+			}
+			return certauth.Allow(nil), nil
+		},
+	)))
+	interceptor := grpcauth.StreamServerInterceptor(auth)
+
+	for _, tc := range []struct {
+		Tenant  string
+		ExpCode codes.Code
+	}{
+		{"foo.com", codes.OK},
+		{"bar.com", codes.PermissionDenied},
+	} {
+		ctx := metadata.NewIncomingContext(
+			peerContext(clientInfo("endpoint", "foo.com")), metadata.Pairs("x-tenant", tc.Tenant),
+		)
+		var cn string
+		err := interceptor(nil, &fakeStream{ctx: ctx}, &grpc.StreamServerInfo{FullMethod: "/foo.v1.Foo/Watch"},
+			func(srv interface{}, ss grpc.ServerStream) error {
+				id, _ := certauth.IdentityFromContext(ss.Context())
+				cn = id.CommonName
+				return nil
+			},
+		)
+		expect(t, status.Code(err), tc.ExpCode)
+		if tc.ExpCode == codes.OK {
+			expect(t, cn, "foo.com")
+		}
+	}
+}
+
+type countingMetrics struct {
+	allowed, denied int
+	reasons         []string
+}
+
+func (m *countingMetrics) ObserveRequest(allowed bool, reason string) {
+	if allowed {
+		m.allowed++
+	} else {
+		m.denied++
+		m.reasons = append(m.reasons, reason)
+	}
+}
+
+func (m *countingMetrics) ObserveChecker(route string, group, checker int, latency time.Duration) {}
+
+func (m *countingMetrics) ObserveCertificate(cert *x509.Certificate) {}
+
+func TestAuthorizeAudit(t *testing.T) {
+	var events []certauth.AuditEvent
+	metrics := &countingMetrics{}
+	auth := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		certauth.WithAuditSink(certauth.AuditSinkFunc(func(ctx context.Context, ev certauth.AuditEvent) {
+			events = append(events, ev)
+		})),
+		certauth.WithMetrics(metrics),
+	)
+
+	_, err := grpcauth.Authorize(peerContext(clientInfo("endpoint", "foo.com")), auth, "/foo.v1.Foo/Get")
+	expect(t, status.Code(err), codes.OK)
+	_, err = grpcauth.Authorize(peerContext(clientInfo("site", "foo.com")), auth, "/foo.v1.Foo/Get")
+	expect(t, status.Code(err), codes.PermissionDenied)
+	_, err = grpcauth.Authorize(peerContext(credentials.TLSInfo{}), auth, "/foo.v1.Foo/Get")
+	expect(t, status.Code(err), codes.Unauthenticated)
+
+	if len(events) != 3 {
+		t.Fatalf("expected 3 audit events, got %d", len(events))
+	}
+	expect(t, events[0].Allowed, true)
+	expect(t, events[0].Method, "POST")
+	expect(t, events[0].Path, "/foo.v1.Foo/Get")
+	expect(t, events[0].RemoteAddr, "127.0.0.1:1234")
+	expect(t, events[1].Allowed, false)
+	expect(t, events[2].Allowed, false)
+	expect(t, metrics.allowed, 1)
+	expect(t, metrics.denied, 2)
+	expect(t, metrics.reasons[1], certauth.ReasonNoClientCert)
+}
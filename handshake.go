@@ -0,0 +1,104 @@
+package certauth
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+)
+
+// WithHandshakeCheckers configures an Auth with a group of checkers enforced during the TLS
+// handshake by VerifyConnection, so that unauthorized clients are rejected before they can send
+// a request. Groups are combined like those of WithCheckers: the handshake passes when all the
+// checkers in any group pass.
+//
+// The handshake checkers only see the client's certificate: there is no HTTP request and no
+// route params, so AuthorizationCheckers are called with CheckAuthorization. Use them for the
+// OU/CN style checks every client must pass whichever resource it requests; requests are still
+// authorized by the Auth's routes and default checkers once the handshake completes.
+func WithHandshakeCheckers(checkers ...AuthorizationChecker) AuthOption {
+	return WithHandshakeRequestCheckers(adaptCheckers(checkers)...)
+}
+
+// WithHandshakeRequestCheckers is like WithHandshakeCheckers for RequestCheckers, which are
+// called with an AuthRequest without a Request or Params
+func WithHandshakeRequestCheckers(checkers ...RequestChecker) AuthOption {
+	return func(a *Auth) {
+		a.handshakeCheckers = append(a.handshakeCheckers, checkers)
+	}
+}
+
+// VerifyConnection enforces the checkers configured with WithHandshakeCheckers. It is meant to be
+// used as the tls.Config.VerifyConnection of a server requiring client certificates, see
+// certutils.TLSServerConfig; it accepts every connection if there are no handshake checkers.
+//
+// When it returns an error the handshake fails with a bad_certificate alert and the server logs
+// the error, e.g. through http.Server.ErrorLog. The denial is also reported to the AuditSink,
+// with AuditEvent.Handshake set.
+func (a *Auth) VerifyConnection(cs tls.ConnectionState) error {
+	a.mu.RLock()
+	groups := a.handshakeCheckers
+	a.mu.RUnlock()
+	if len(groups) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+		a.auditHandshake(start, nil, evaluation{group: -1, err: ErrNoClientCert})
+		return ErrNoClientCert
+	}
+	req := &AuthRequest{
+		Certificate:    cs.VerifiedChains[0][0],
+		VerifiedChains: cs.VerifiedChains,
+	}
+	res := evaluation{group: -1}
+	if res.err = a.identify(req); res.err == nil {
+		res = a.runGroups(context.Background(), req, "", groups)
+	}
+	if res.err != nil {
+		a.auditHandshake(start, req, res)
+	}
+	return res.err
+}
+
+// auditHandshake reports a handshake denial to the AuditSink
+func (a *Auth) auditHandshake(start time.Time, req *AuthRequest, res evaluation) {
+	if a.auditSink == nil {
+		return
+	}
+	ev := newAuditEvent(start, req, res)
+	ev.Handshake = true
+	a.auditSink.Audit(context.Background(), ev)
+}
+
+// VerifyServer returns a function to be used as the tls.Config.VerifyConnection of a client, see
+// certutils.TLSClientConfig, which applies the Auth's default checker groups to the certificate
+// of the server being called: the client refuses to talk to a server which doesn't pass them.
+// Build a dedicated Auth for the servers a client calls, e.g.
+//
+//	peers := certauth.New(certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)))
+//	tlsConfig.VerifyConnection = peers.VerifyServer(nil)
+//
+// `ps`, which may be nil, are passed to the checkers in place of route params, e.g. the site the
+// client expects to reach for checkers such as pantheon.PantheonSiteAuth. Routes are not
+// consulted. A server without a verified chain is refused with ErrNoServerCert.
+func (a *Auth) VerifyServer(ps Params) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.VerifiedChains) == 0 || len(cs.VerifiedChains[0]) == 0 {
+			return ErrNoServerCert
+		}
+		a.mu.RLock()
+		groups := a.checkers
+		a.mu.RUnlock()
+
+		req := &AuthRequest{
+			Certificate:    cs.VerifiedChains[0][0],
+			VerifiedChains: cs.VerifiedChains,
+			Params:         normalizeParams(ps),
+		}
+		if err := a.identify(req); err != nil {
+			return err
+		}
+		return a.runGroups(context.Background(), req, "", groups).err
+	}
+}
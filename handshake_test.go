@@ -0,0 +1,177 @@
+package certauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/certauthtest"
+)
+
+// handshake connects a client presenting `client` to a server using `verify`, returning the
+// errors of both sides
+func handshake(t *testing.T, ca *certauthtest.CA, client tls.Certificate, verify func(tls.ConnectionState) error) (error, error) {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		config := ca.ServerConfig(t)
+		config.VerifyConnection = verify
+		conn := tls.Server(serverConn, config)
+		err := conn.Handshake()
+		if err == nil {
+			_, err = conn.Write([]byte("ok"))
+		}
+		serverErr <- err
+		conn.Close()
+	}()
+
+	config := ca.ClientConfig(client)
+	config.ServerName = "localhost"
+	conn := tls.Client(clientConn, config)
+	err := conn.Handshake()
+	if err == nil {
+		// with TLS 1.3 the client learns the server rejected its certificate on the first read
+		_, err = conn.Read(make([]byte, 2))
+	}
+	return <-serverErr, err
+}
+
+func TestVerifyConnection(t *testing.T) {
+	ca := certauthtest.NewCA(t)
+
+	var events []certauth.AuditEvent
+	auth := certauth.New(
+		certauth.WithHandshakeCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		certauth.WithHandshakeCheckers(certauth.AllowOUsandCNs(nil, []string{"admin"})),
+		certauth.WithAuditSink(certauth.AuditSinkFunc(func(ctx context.Context, ev certauth.AuditEvent) {
+			events = append(events, ev)
+		})),
+	)
+
+	tests := []struct {
+		Name    string
+		Client  certauthtest.Options
+		Allowed bool
+	}{
+		{"EndpointOU", certauthtest.Options{OrganizationalUnits: []string{"endpoint"}, CommonName: "foo.com"}, true},
+		{"AdminCN", certauthtest.Options{OrganizationalUnits: []string{"site"}, CommonName: "admin"}, true},
+		{"Denied", certauthtest.Options{OrganizationalUnits: []string{"site"}, CommonName: "foo.com"}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			events = nil
+			serverErr, clientErr := handshake(t2, ca, ca.IssueClient(t2, tc.Client), auth.VerifyConnection)
+			if tc.Allowed {
+				expectErr(t2, serverErr, nil)
+				expectErr(t2, clientErr, nil)
+				expect(t2, len(events), 0)
+				return
+			}
+			var authErr *certauth.AuthorizationError
+			if !errors.As(serverErr, &authErr) {
+				t2.Fatalf("expected an AuthorizationError, got %v", serverErr)
+			}
+			if clientErr == nil || !strings.Contains(clientErr.Error(), "bad certificate") {
+				t2.Errorf("expected a bad certificate alert, got %v", clientErr)
+			}
+			expect(t2, len(events), 1)
+			expect(t2, events[0].Handshake, true)
+			expect(t2, events[0].Subject, "CN=foo.com,OU=site")
+			expect(t2, events[0].Reason, serverErr.Error())
+		})
+	}
+
+	// without handshake checkers every verified client is accepted
+	client := ca.IssueClient(t, certauthtest.Options{CommonName: "foo.com"})
+	serverErr, _ := handshake(t, ca, client, certauth.New().VerifyConnection)
+	expectErr(t, serverErr, nil)
+	expectErr(t, auth.VerifyConnection(tls.ConnectionState{}), certauth.ErrNoClientCert)
+}
+
+// dialServer connects a client using `verify` to a server presenting `server`, returning the
+// client's handshake error
+func dialServer(t *testing.T, ca *certauthtest.CA, server tls.Certificate, verify func(tls.ConnectionState) error) error {
+	t.Helper()
+	// a buffered TCP connection, as the client sends its alert while the server writes its flight
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		serverConn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{server}})
+		conn.Handshake()
+		conn.Close()
+	}()
+
+	clientConn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	conn := tls.Client(clientConn, &tls.Config{
+		RootCAs:          ca.Pool(),
+		ServerName:       "localhost",
+		VerifyConnection: verify,
+	})
+	return conn.Handshake()
+}
+
+func TestVerifyServer(t *testing.T) {
+	ca := certauthtest.NewCA(t)
+	peers := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		certauth.WithRequestCheckers(certauth.RequestCheckerFunc(
+			func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+				if req.Params != nil && req.Identity.CommonName == req.Params.ByName("site")+".example.org" {
+					return certauth.Allow(nil), nil
+				}
+				return certauth.Deny("not the expected site"), nil
+			},
+		)),
+	)
+
+	tests := []struct {
+		Name   string
+		Server certauthtest.Options
+		Params certauth.Params
+		Err    string
+	}{
+		{"EndpointOU", certauthtest.Options{OrganizationalUnits: []string{"endpoint"}, CommonName: "api"}, nil, ""},
+		{"Site", certauthtest.Options{OrganizationalUnits: []string{"site"}, CommonName: "foo.example.org"},
+			certauth.ParamsMap{"site": "foo"}, ""},
+		{"OtherSite", certauthtest.Options{OrganizationalUnits: []string{"site"}, CommonName: "bar.example.org"},
+			certauth.ParamsMap{"site": "foo"}, "not the expected site"},
+		{"NoParams", certauthtest.Options{OrganizationalUnits: []string{"site"}, CommonName: "foo.example.org"},
+			certauth.ParamsMap(nil), "not the expected site"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			err := dialServer(t2, ca, ca.IssueServer(t2, tc.Server), peers.VerifyServer(tc.Params))
+			if tc.Err == "" {
+				expectErr(t2, err, nil)
+				return
+			}
+			var authErr *certauth.AuthorizationError
+			if !errors.As(err, &authErr) || !strings.Contains(err.Error(), tc.Err) {
+				t2.Errorf("expected an AuthorizationError containing %q, got %v", tc.Err, err)
+			}
+		})
+	}
+
+	err := peers.VerifyServer(nil)(tls.ConnectionState{})
+	expectErr(t, err, certauth.ErrNoServerCert)
+	expect(t, certauth.ReasonFor(err), certauth.ReasonNoServerCert)
+}
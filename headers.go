@@ -0,0 +1,184 @@
+package certauth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultHeaderPrefix is prepended to every header name populated by WithHeaders
+const DefaultHeaderPrefix = "X-Client-Cert-"
+
+// HeaderNames configures the names of the request headers populated when header injection is
+// enabled with WithHeaders. Each name is appended to the header prefix. Empty names are filled in
+// from DefaultHeaderNames.
+type HeaderNames struct {
+	Subject             string
+	CommonName          string
+	OrganizationalUnits string
+	DNSNames            string
+	EmailAddresses      string
+	IPAddresses         string
+	URIs                string
+	Serial              string
+	Fingerprint         string
+	Issuer              string
+}
+
+// DefaultHeaderNames are the header names used by WithHeaders when none are configured
+var DefaultHeaderNames = HeaderNames{
+	Subject:             "Subject",
+	CommonName:          "CN",
+	OrganizationalUnits: "OU",
+	DNSNames:            "DNS",
+	EmailAddresses:      "Email",
+	IPAddresses:         "IP",
+	URIs:                "URI",
+	Serial:              "Serial",
+	Fingerprint:         "Fingerprint",
+	Issuer:              "Issuer",
+}
+
+// HeaderKey can be implemented by the ContextKeys returned from an AuthorizationChecker. When
+// header injection is enabled, string (or []string) values stored under such keys are also set as
+// request headers named by the header prefix followed by HeaderName(), with one header per value
+// of a []string.
+type HeaderKey interface {
+	HeaderName() string
+}
+
+// HeaderKeyProvider can be implemented by checkers which add values under HeaderKeys. It lists
+// every key the checker may set, so that client supplied copies of those headers are removed
+// even from requests the checker adds no value to, and whatever the header prefix.
+type HeaderKeyProvider interface {
+	HeaderKeys() []HeaderKey
+}
+
+// withDefaults fills in any empty names from DefaultHeaderNames
+func (n HeaderNames) withDefaults() HeaderNames {
+	fill := func(name *string, def string) {
+		if *name == "" {
+			*name = def
+		}
+	}
+	fill(&n.Subject, DefaultHeaderNames.Subject)
+	fill(&n.CommonName, DefaultHeaderNames.CommonName)
+	fill(&n.OrganizationalUnits, DefaultHeaderNames.OrganizationalUnits)
+	fill(&n.DNSNames, DefaultHeaderNames.DNSNames)
+	fill(&n.EmailAddresses, DefaultHeaderNames.EmailAddresses)
+	fill(&n.IPAddresses, DefaultHeaderNames.IPAddresses)
+	fill(&n.URIs, DefaultHeaderNames.URIs)
+	fill(&n.Serial, DefaultHeaderNames.Serial)
+	fill(&n.Fingerprint, DefaultHeaderNames.Fingerprint)
+	fill(&n.Issuer, DefaultHeaderNames.Issuer)
+	return n
+}
+
+func (n HeaderNames) list() []string {
+	return []string{
+		n.Subject, n.CommonName, n.OrganizationalUnits, n.DNSNames, n.EmailAddresses,
+		n.IPAddresses, n.URIs, n.Serial, n.Fingerprint, n.Issuer,
+	}
+}
+
+// stripHeaders removes any client supplied copies of the headers we populate, so that a
+// downstream handler can trust them. Every header starting with the prefix is removed, as well as
+// each of the configured names and the names of the HeaderKeys declared by the checkers.
+func (a *Auth) stripHeaders(h http.Header) {
+	prefix := http.CanonicalHeaderKey(a.headerPrefix)
+	if prefix != "" {
+		for k := range h {
+			if strings.HasPrefix(http.CanonicalHeaderKey(k), prefix) {
+				delete(h, k)
+			}
+		}
+	}
+	for _, name := range a.headerNames.list() {
+		h.Del(a.headerPrefix + name)
+	}
+	for _, k := range a.headerKeys() {
+		h.Del(a.headerPrefix + k.HeaderName())
+	}
+}
+
+// headerKeys returns the HeaderKeys declared by the checkers of the default groups and routes
+func (a *Auth) headerKeys() []HeaderKey {
+	var keys []HeaderKey
+	add := func(groups [][]RequestChecker) {
+		for _, cks := range groups {
+			for _, ck := range cks {
+				if p, ok := ck.(HeaderKeyProvider); ok {
+					keys = append(keys, p.HeaderKeys()...)
+				}
+			}
+		}
+	}
+	a.mu.RLock()
+	add(a.checkers)
+	a.mu.RUnlock()
+	for _, rt := range a.routes {
+		add(rt.groups)
+	}
+	return keys
+}
+
+// setHeaderValues populates the request headers from the client's identity and the
+// context values collected from the AuthorizationCheckers.
+func (a *Auth) setHeaderValues(
+	h http.Header, id *Identity, ctxParams map[ContextKey]ContextValue,
+) {
+	set := func(name, value string) {
+		if v := headerValue(value); v != "" {
+			h.Set(a.headerPrefix+name, v)
+		}
+	}
+	// lists are set as one header per value, as their values may contain commas
+	add := func(name string, values ...string) {
+		for _, value := range values {
+			if v := headerValue(value); v != "" {
+				h.Add(a.headerPrefix+name, v)
+			}
+		}
+	}
+
+	names := a.headerNames
+	set(names.Subject, id.Subject)
+	set(names.CommonName, id.CommonName)
+	add(names.OrganizationalUnits, id.OrganizationalUnits...)
+	add(names.DNSNames, id.DNSNames...)
+	add(names.EmailAddresses, id.EmailAddresses...)
+	for _, ip := range id.IPAddresses {
+		add(names.IPAddresses, ip.String())
+	}
+	for _, u := range id.URIs {
+		add(names.URIs, u.String())
+	}
+	set(names.Serial, id.SerialNumber)
+	set(names.Fingerprint, id.Fingerprint)
+	set(names.Issuer, id.Issuer)
+
+	for k, v := range ctxParams {
+		hk, ok := k.(HeaderKey)
+		if !ok {
+			continue
+		}
+		switch val := v.(type) {
+		case string:
+			set(hk.HeaderName(), val)
+		case []string:
+			add(hk.HeaderName(), val...)
+		case fmt.Stringer:
+			set(hk.HeaderName(), val.String())
+		}
+	}
+}
+
+// headerValue drops characters which are not permitted in a header value
+func headerValue(v string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return -1
+		}
+		return r
+	}, v)
+}
@@ -0,0 +1,200 @@
+package certauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// headerTestCert returns a fake verified chain whose leaf has every field we turn into a header
+func headerTestCert() [][]*x509.Certificate {
+	spiffe, _ := url.Parse("spiffe://example.org/worker")
+	cert := &x509.Certificate{
+		Raw:          []byte("not really DER"),
+		SerialNumber: big.NewInt(0xbeef),
+		Subject: pkix.Name{
+			OrganizationalUnit: []string{"endpoint", "titan"},
+			CommonName:         "foo.com",
+		},
+		Issuer:         pkix.Name{CommonName: "Test CA"},
+		DNSNames:       []string{"foo.com", "www.foo.com"},
+		EmailAddresses: []string{"ops@foo.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")},
+		URIs:           []*url.URL{spiffe},
+	}
+	return [][]*x509.Certificate{{cert}}
+}
+
+func serveHeaders(auth *certauth.Auth, chains [][]*x509.Certificate, hdr http.Header) (*httptest.ResponseRecorder, http.Header) {
+	var seen http.Header
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+	}))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "https://foo.bar/foo", nil)
+	for k, v := range hdr {
+		req.Header[k] = v
+	}
+	req.TLS = &tls.ConnectionState{VerifiedChains: chains}
+	handler.ServeHTTP(w, req)
+	return w, seen
+}
+
+func TestWithHeaders(t *testing.T) {
+	auth := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		certauth.WithHeaders(),
+	)
+
+	w, hdr := serveHeaders(auth, headerTestCert(), http.Header{
+		"X-Client-Cert-Cn":     {"admin"},
+		"X-Client-Cert-Bogus":  {"spoofed"},
+		"X-Forwarded-For":      {"1.2.3.4"},
+		"X-Client-Cert-Serial": {"1"},
+	})
+	expect(t, w.Code, http.StatusOK)
+
+	tests := map[string]string{
+		"X-Client-Cert-Subject":     "CN=foo.com,OU=endpoint+OU=titan",
+		"X-Client-Cert-Cn":          "foo.com",
+		"X-Client-Cert-Email":       "ops@foo.com",
+		"X-Client-Cert-Uri":         "spiffe://example.org/worker",
+		"X-Client-Cert-Serial":      "beef",
+		"X-Client-Cert-Fingerprint": "4765b990e273236d89418664d51837b9f0d0766b7ba129c698a3cb2225eb9cc6",
+		"X-Client-Cert-Issuer":      "CN=Test CA",
+		"X-Client-Cert-Bogus":       "",
+		"X-Forwarded-For":           "1.2.3.4",
+	}
+	for name, value := range tests {
+		expect(t, hdr.Get(name), value)
+	}
+	lists := map[string][]string{
+		"X-Client-Cert-Ou":  {"endpoint", "titan"},
+		"X-Client-Cert-Dns": {"foo.com", "www.foo.com"},
+		"X-Client-Cert-Ip":  {"10.0.0.1", "10.0.0.2"},
+	}
+	for name, values := range lists {
+		if got := hdr.Values(name); !reflect.DeepEqual(got, values) {
+			t.Errorf("unexpected %s headers: %v", name, got)
+		}
+	}
+}
+
+func TestWithHeadersCustomNames(t *testing.T) {
+	auth := certauth.New(
+		certauth.WithHeaderPrefix("X-SSL-"),
+		certauth.WithHeaderNames(certauth.HeaderNames{Subject: "Client-DN"}),
+	)
+
+	_, hdr := serveHeaders(auth, headerTestCert(), http.Header{
+		"X-Ssl-Client-Dn":     {"CN=admin"},
+		"X-Client-Cert-Cn":    {"untouched"},
+		"X-Ssl-Anything-Else": {"spoofed"},
+	})
+
+	expect(t, hdr.Get("X-SSL-Client-DN"), "CN=foo.com,OU=endpoint+OU=titan")
+	expect(t, hdr.Get("X-SSL-CN"), "foo.com")
+	expect(t, hdr.Get("X-SSL-Anything-Else"), "")
+	// headers outside our prefix are not ours to strip
+	expect(t, hdr.Get("X-Client-Cert-CN"), "untouched")
+}
+
+func TestWithHeadersLegacyOption(t *testing.T) {
+	auth := certauth.NewAuth(certauth.Options{SetReqHeaders: true})
+
+	_, hdr := serveHeaders(auth, headerTestCert(), nil)
+	expect(t, hdr.Get("X-Client-Cert-CN"), "foo.com")
+}
+
+func TestWithoutHeaders(t *testing.T) {
+	auth := certauth.New()
+
+	_, hdr := serveHeaders(auth, headerTestCert(), http.Header{"X-Client-Cert-Cn": {"admin"}})
+	// header injection is disabled, so the request is passed through untouched
+	expect(t, hdr.Get("X-Client-Cert-CN"), "admin")
+	expect(t, hdr.Get("X-Client-Cert-Subject"), "")
+}
+
+func TestWithHeadersDenied(t *testing.T) {
+	auth := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"site"}, nil)),
+		certauth.WithHeaders(),
+	)
+
+	req, _ := http.NewRequest("GET", "https://foo.bar/foo", nil)
+	req.Header.Set("X-Client-Cert-CN", "admin")
+	req.TLS = &tls.ConnectionState{VerifiedChains: headerTestCert()}
+	_, err := auth.Process(httptest.NewRecorder(), req)
+	if err == nil {
+		t.Fatal("expected authorization to fail")
+	}
+	// spoofed headers are removed even when the request is rejected
+	expect(t, req.Header.Get("X-Client-Cert-CN"), "")
+	expect(t, req.Header.Get("X-Client-Cert-Subject"), "")
+}
+
+type legacyHeaderKey string
+
+func (k legacyHeaderKey) HeaderName() string { return string(k) }
+
+// siteHeaderChecker sets Legacy-Site for the "site" OU only
+type siteHeaderChecker struct{}
+
+func (siteHeaderChecker) HeaderKeys() []certauth.HeaderKey {
+	return []certauth.HeaderKey{legacyHeaderKey("Legacy-Site")}
+}
+
+func (siteHeaderChecker) Check(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+	for _, ou := range req.Identity.OrganizationalUnits {
+		if ou == "site" {
+			return certauth.Allow(certauth.Claims{legacyHeaderKey("Legacy-Site"): "mine"}), nil
+		}
+	}
+	return certauth.Allow(nil), nil
+}
+
+func TestWithHeadersEmptyPrefix(t *testing.T) {
+	for _, prefix := range []string{"", "X-SSL-"} {
+		auth := certauth.New(
+			certauth.WithRequestCheckers(siteHeaderChecker{}),
+			certauth.WithHeaderPrefix(prefix),
+		)
+
+		// the checker sets no value for this client, the spoofed header must still go
+		w, hdr := serveHeaders(auth, headerTestCert(), http.Header{
+			http.CanonicalHeaderKey(prefix + "Legacy-Site"): {"victim"},
+		})
+		expect(t, w.Code, http.StatusOK)
+		expect(t, hdr.Get(prefix+"Legacy-Site"), "")
+		expect(t, hdr.Get(prefix+"CN"), "foo.com")
+	}
+}
+
+func TestWithHeadersListClaims(t *testing.T) {
+	sites := legacyHeaderKey("Sites")
+	auth := certauth.New(
+		certauth.WithRequestCheckers(certauth.RequestCheckerFunc(
+			func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+				return certauth.Allow(certauth.Claims{sites: []string{"a,b", "c"}}), nil
+			},
+		)),
+		certauth.WithHeaders(),
+	)
+
+	_, hdr := serveHeaders(auth, headerTestCert(), nil)
+	// one header per value, so values containing commas are kept intact
+	if got := hdr.Values("X-Client-Cert-Sites"); !reflect.DeepEqual(got, []string{"a,b", "c"}) {
+		t.Errorf("unexpected Sites headers: %v", got)
+	}
+}
@@ -0,0 +1,131 @@
+package certauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"net/url"
+)
+
+// Identity describes the authenticated client as determined from its verified x509 certificate.
+// It is produced by an IdentityExtractor and consumed by AuthorizationCheckers.
+type Identity struct {
+	// Certificate is the verified leaf certificate the Identity was extracted from
+	Certificate *x509.Certificate
+
+	// VerifiedChains are the chains built by crypto/tls when verifying Certificate. They are set
+	// by the Auth when authorizing a request and are nil when the Identity was built elsewhere.
+	VerifiedChains [][]*x509.Certificate
+
+	// Subject fields
+	Subject             string
+	CommonName          string
+	OrganizationalUnits []string
+	Organizations       []string
+
+	// Subject Alternative Names
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+
+	// SerialNumber is the hex encoded certificate serial number
+	SerialNumber string
+	// Issuer is the string form of the issuer's distinguished name
+	Issuer string
+	// Fingerprint is the hex encoded SHA-256 digest of the DER encoded certificate
+	Fingerprint string
+	// KeyFingerprint is the hex encoded SHA-256 digest of the DER encoded public key
+	KeyFingerprint string
+
+	// Extensions holds the raw value of every certificate extension keyed by its dotted OID
+	Extensions map[string][]byte
+
+	// Attributes is free for custom IdentityExtractors to record anything else they derive from
+	// the certificate
+	Attributes map[string]string
+}
+
+// IdentityFromContext returns the Identity of the client authorized by the Auth, including its
+// verified certificate and chains. It is set on the request passed to the next handler.
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(IdentityKey).(*Identity)
+	return id, ok && id != nil
+}
+
+// AuthorizedOUsFromContext returns the client OUs added to the request context by
+// AllowSpecificOUandCNs when it checks the OU
+func AuthorizedOUsFromContext(ctx context.Context) ([]string, bool) {
+	ous, ok := ctx.Value(HasAuthorizedOU).([]string)
+	return ous, ok
+}
+
+// AuthorizedCNFromContext returns the client CN added to the request context by
+// AllowSpecificOUandCNs when it checks the CN
+func AuthorizedCNFromContext(ctx context.Context) (string, bool) {
+	cn, ok := ctx.Value(HasAuthorizedCN).(string)
+	return cn, ok
+}
+
+// IdentityExtractor builds the Identity for a verified client certificate. Returning an error
+// denies the request.
+// A custom extractor will usually start from ExtractIdentity and adjust the result, e.g. setting
+// CommonName from a URI SAN so existing OU/CN checkers keep working with certs that no longer
+// carry a meaningful CN.
+type IdentityExtractor func(cert *x509.Certificate) (*Identity, error)
+
+// IdentityChecker may be implemented by an AuthorizationChecker which needs more of the client's
+// identity than its OUs and CN. When implemented, CheckIdentity is called instead of the
+// CheckAuthorization* methods.
+// `ps` is nil for requests which have no route params.
+type IdentityChecker interface {
+	CheckIdentity(id *Identity, ps Params) (map[ContextKey]ContextValue, error)
+}
+
+// WithIdentityExtractor configures an Auth to build client identities using the given
+// IdentityExtractor instead of ExtractIdentity.
+func WithIdentityExtractor(extractor IdentityExtractor) AuthOption {
+	return func(a *Auth) {
+		a.extractor = extractor
+	}
+}
+
+// ExtractIdentity is the default IdentityExtractor. It copies the subject, SANs, serial, issuer,
+// fingerprints and extensions from the certificate.
+func ExtractIdentity(cert *x509.Certificate) (*Identity, error) {
+	if cert == nil {
+		return nil, errors.New("no client certificate")
+	}
+
+	id := &Identity{
+		Certificate:         cert,
+		Subject:             cert.Subject.String(),
+		CommonName:          cert.Subject.CommonName,
+		OrganizationalUnits: cert.Subject.OrganizationalUnit,
+		Organizations:       cert.Subject.Organization,
+		DNSNames:            cert.DNSNames,
+		EmailAddresses:      cert.EmailAddresses,
+		IPAddresses:         cert.IPAddresses,
+		URIs:                cert.URIs,
+		Issuer:              cert.Issuer.String(),
+		Fingerprint:         sha256Hex(cert.Raw),
+		KeyFingerprint:      sha256Hex(cert.RawSubjectPublicKeyInfo),
+		Extensions:          make(map[string][]byte, len(cert.Extensions)),
+		Attributes:          make(map[string]string),
+	}
+	if cert.SerialNumber != nil {
+		id.SerialNumber = cert.SerialNumber.Text(16)
+	}
+	for _, ext := range cert.Extensions {
+		id.Extensions[ext.Id.String()] = ext.Value
+	}
+	return id, nil
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
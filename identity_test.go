@@ -0,0 +1,160 @@
+package certauth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pantheon-systems/go-certauth"
+)
+
+func TestExtractIdentity(t *testing.T) {
+	cert := headerTestCert()[0][0]
+	cert.Subject.Organization = []string{"Pantheon"}
+	cert.RawSubjectPublicKeyInfo = []byte("not really a key")
+	cert.Extensions = []pkix.Extension{
+		{Id: asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 99999, 1}, Value: []byte("custom")},
+	}
+
+	id, err := certauth.ExtractIdentity(cert)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expect(t, id.Certificate, cert)
+	expect(t, id.Subject, "CN=foo.com,OU=endpoint+OU=titan,O=Pantheon")
+	expect(t, id.CommonName, "foo.com")
+	expect(t, fmt.Sprint(id.OrganizationalUnits), "[endpoint titan]")
+	expect(t, fmt.Sprint(id.Organizations), "[Pantheon]")
+	expect(t, fmt.Sprint(id.DNSNames), "[foo.com www.foo.com]")
+	expect(t, fmt.Sprint(id.EmailAddresses), "[ops@foo.com]")
+	expect(t, fmt.Sprint(id.IPAddresses), "[10.0.0.1 10.0.0.2]")
+	expect(t, id.URIs[0].String(), "spiffe://example.org/worker")
+	expect(t, id.SerialNumber, "beef")
+	expect(t, id.Issuer, "CN=Test CA")
+	expect(t, id.Fingerprint, "4765b990e273236d89418664d51837b9f0d0766b7ba129c698a3cb2225eb9cc6")
+	expect(t, id.KeyFingerprint, "d592d30f949df77f9015029414b25c1fc2e7aee460846349d8f2dcd4849b79f7")
+	expect(t, string(id.Extensions["1.3.6.1.4.1.99999.1"]), "custom")
+
+	_, err = certauth.ExtractIdentity(nil)
+	expectErr(t, err, errors.New("no client certificate"))
+}
+
+// uriAsCN is an IdentityExtractor which identifies clients by their first URI SAN
+func uriAsCN(cert *x509.Certificate) (*certauth.Identity, error) {
+	id, err := certauth.ExtractIdentity(cert)
+	if err != nil {
+		return nil, err
+	}
+	if len(id.URIs) == 0 {
+		return nil, errors.New("no URI SAN")
+	}
+	id.CommonName = id.URIs[0].String()
+	return id, nil
+}
+
+func TestWithIdentityExtractor(t *testing.T) {
+	auth := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs(nil, []string{"spiffe://example.org/worker"})),
+		certauth.WithIdentityExtractor(uriAsCN),
+	)
+
+	_, err := auth.CheckAuthorization(headerTestCert()[0][0], nil)
+	expectErr(t, err, nil)
+
+	_, err = auth.CheckAuthorization(fakeCertChain(fakeCertData{nil, "foo.com"})[0][0], nil)
+	expectErr(t, err, errors.New("no URI SAN"))
+
+	// extractor failures are rejected by the middleware too
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "https://foo.bar/foo", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: fakeCertChain(fakeCertData{nil, "foo.com"})}
+	auth.Handler(http.NotFoundHandler()).ServeHTTP(w, req)
+	expect(t, w.Code, http.StatusForbidden)
+}
+
+func TestIdentityFromContext(t *testing.T) {
+	auth := certauth.New(certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, []string{"foo.com"})))
+	chains := headerTestCert()
+
+	var (
+		id     *certauth.Identity
+		ous    []string
+		cn     string
+		found  bool
+		params bool
+	)
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, found = certauth.IdentityFromContext(r.Context())
+		ous, params = certauth.AuthorizedOUsFromContext(r.Context())
+		cn, _ = certauth.AuthorizedCNFromContext(r.Context())
+	}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "https://foo.bar/foo", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: chains}
+	handler.ServeHTTP(w, req)
+
+	expect(t, w.Code, http.StatusOK)
+	expect(t, found, true)
+	expect(t, params, true)
+	expect(t, id.CommonName, "foo.com")
+	expect(t, id.Certificate, chains[0][0])
+	expect(t, len(id.VerifiedChains), 1)
+	expect(t, id.VerifiedChains[0][0], chains[0][0])
+	expect(t, len(ous), 2)
+	expect(t, cn, "foo.com")
+
+	_, found = certauth.IdentityFromContext(req.Context())
+	expect(t, found, false)
+}
+
+// orgChecker is an IdentityChecker allowing clients from a single Organization
+type orgChecker struct {
+	org string
+}
+
+func (c orgChecker) CheckIdentity(
+	id *certauth.Identity, ps certauth.Params,
+) (map[certauth.ContextKey]certauth.ContextValue, error) {
+	for _, org := range id.Organizations {
+		if org == c.org {
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("organization %v is not %q", id.Organizations, c.org)
+}
+
+func (c orgChecker) CheckAuthorization(
+	clientOU []string, clientCN string,
+) (map[certauth.ContextKey]certauth.ContextValue, error) {
+	return nil, errors.New("CheckAuthorization should not be called for an IdentityChecker")
+}
+
+func (c orgChecker) CheckAuthorizationWithParams(
+	clientOU []string, clientCN string, ps certauth.Params,
+) (map[certauth.ContextKey]certauth.ContextValue, error) {
+	return c.CheckAuthorization(clientOU, clientCN)
+}
+
+func TestIdentityChecker(t *testing.T) {
+	auth := certauth.New(
+		certauth.WithCheckers(orgChecker{"Pantheon"}, certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+	)
+
+	cert := headerTestCert()[0][0]
+	cert.Subject.Organization = []string{"Pantheon"}
+	_, err := auth.CheckAuthorization(cert, nil)
+	expectErr(t, err, nil)
+	_, err = auth.CheckAuthorization(cert, httprouter.Params{{Key: "site", Value: "foo"}})
+	expectErr(t, err, nil)
+
+	cert.Subject.Organization = []string{"Elsewhere"}
+	_, err = auth.CheckAuthorization(cert, nil)
+	expectErr(t, err, errors.New(`organization [Elsewhere] is not "Pantheon"`))
+}
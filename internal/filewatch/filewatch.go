@@ -0,0 +1,96 @@
+// Package filewatch polls files on disk for changes. It backs certutils.Reloader and
+// policy.Watcher.
+package filewatch
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Poller reads a set of files and hands their contents to an apply function whenever they change.
+// Files are compared by their contents rather than their modification times, which also catches
+// the symlink swaps used when mounting secrets and config maps into containers.
+type Poller struct {
+	files    []string
+	interval time.Duration
+	apply    func(files map[string][]byte) error
+
+	mu      sync.Mutex
+	digests map[string][32]byte
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// New creates a Poller for `files`, which are checked every `interval` once started. `apply` is
+// called with the contents of every file, keyed by name, when any of them changed; they are only
+// considered applied if it returns nil.
+func New(files []string, interval time.Duration, apply func(files map[string][]byte) error) *Poller {
+	return &Poller{
+		files:    files,
+		interval: interval,
+		apply:    apply,
+		digests:  make(map[string][32]byte),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Poll reads the files and applies them if they changed since they were last applied, or
+// regardless if `force` is set. It reports whether they were applied.
+func (p *Poller) Poll(force bool) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	files := make(map[string][]byte, len(p.files))
+	digests := make(map[string][32]byte, len(p.files))
+	changed := force
+	for _, name := range p.files {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return false, fmt.Errorf("could not read %s: %s", name, err.Error())
+		}
+		files[name] = data
+		digests[name] = sha256.Sum256(data)
+		if digests[name] != p.digests[name] {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+
+	if err := p.apply(files); err != nil {
+		return false, err
+	}
+	p.digests = digests
+	return true, nil
+}
+
+// Start calls `reload` in the background every interval until Stop is called, passing the errors
+// it returns to `onError` if it is not nil
+func (p *Poller) Start(reload func() error, onError func(error)) {
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				if err := reload(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops polling. It is safe to call more than once.
+func (p *Poller) Stop() {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+}
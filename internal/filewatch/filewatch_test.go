@@ -0,0 +1,56 @@
+package filewatch_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth/internal/filewatch"
+)
+
+func TestPoll(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte("one"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		applied []string
+		failing bool
+	)
+	p := filewatch.New([]string{path}, time.Hour, func(files map[string][]byte) error {
+		if failing {
+			return errors.New("invalid")
+		}
+		applied = append(applied, string(files[path]))
+		return nil
+	})
+	poll := func(force, expChanged, expErr bool) {
+		t.Helper()
+		changed, err := p.Poll(force)
+		if changed != expChanged || (err != nil) != expErr {
+			t.Fatalf("expected changed=%v err=%v, got %v %v", expChanged, expErr, changed, err)
+		}
+	}
+
+	poll(true, true, false)
+	poll(false, false, false)
+	poll(true, true, false)
+
+	// contents which fail to apply are retried on the next poll
+	os.WriteFile(path, []byte("two"), 0o600)
+	failing = true
+	poll(false, false, true)
+	failing = false
+	poll(false, true, false)
+	poll(false, false, false)
+
+	os.Remove(path)
+	poll(false, false, true)
+
+	if len(applied) != 3 || applied[2] != "two" {
+		t.Errorf("unexpected applied contents: %v", applied)
+	}
+}
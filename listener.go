@@ -0,0 +1,179 @@
+package certauth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// ListenerConfig is the configuration of a listener created with Auth.NewListener
+type ListenerConfig struct {
+	// TLSConfig is the server's TLS configuration. It must verify client certificates, e.g. with
+	// ClientAuth set to tls.RequireAndVerifyClientCert, for clients to be authorized.
+	TLSConfig *tls.Config
+
+	// HandshakeTimeout bounds the TLS handshake of each connection. Defaults to 10 seconds.
+	HandshakeTimeout time.Duration
+
+	// OnReject, if set, is called with each connection which failed its handshake or was not
+	// authorized, and the error why, before it is closed
+	OnReject func(conn net.Conn, err error)
+}
+
+// AuthenticatedConn is a TLS connection whose client was authorized by an Auth. It is returned by
+// the Accept method of a listener created with Auth.NewListener.
+type AuthenticatedConn struct {
+	*tls.Conn
+
+	// Identity is the authorized client's identity
+	Identity *Identity
+	// Claims are the values attached by the checkers which authorized the client
+	Claims Claims
+}
+
+// Context returns a context holding the connection's identity and claims, as they are attached to
+// authorized HTTP requests, so they can be read with IdentityFromContext, Get, etc.
+func (c *AuthenticatedConn) Context(parent context.Context) context.Context {
+	return NewContext(parent, c.Identity, c.Claims)
+}
+
+// NewListener wraps `inner`, a listener accepting plain TCP connections, into one whose Accept
+// returns only the connections of authorized clients, as *AuthenticatedConn. It is meant for
+// protocols other than HTTP; HTTP servers should use Handler, which keeps the http.Server aware
+// of the TLS connection.
+//
+// Each connection's TLS handshake is performed in the background, then its client is authorized
+// by the Auth's default checker groups: there is no HTTP request so routes never match. Rejected
+// connections are closed, reported to the AuditSink and Metrics like denied requests and never
+// returned by Accept.
+func (a *Auth) NewListener(inner net.Listener, cfg ListenerConfig) net.Listener {
+	if cfg.HandshakeTimeout <= 0 {
+		cfg.HandshakeTimeout = 10 * time.Second
+	}
+	l := &authListener{
+		Listener: inner,
+		auth:     a,
+		cfg:      cfg,
+		conns:    make(chan *AuthenticatedConn),
+		errs:     make(chan error),
+		done:     make(chan struct{}),
+	}
+	// connections are accepted and authorized in the background, so a slow handshake doesn't hold
+	// up the others
+	go l.acceptLoop()
+	return l
+}
+
+type authListener struct {
+	net.Listener
+	auth *Auth
+	cfg  ListenerConfig
+
+	conns     chan *AuthenticatedConn
+	errs      chan error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept waits for and returns the next authorized connection
+func (l *authListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the inner listener; connections still in their handshake are closed too
+func (l *authListener) Close() error {
+	l.closeOnce.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+func (l *authListener) acceptLoop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+				return
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		go l.handle(conn)
+	}
+}
+
+func (l *authListener) handle(conn net.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), l.cfg.HandshakeTimeout)
+	defer cancel()
+	go func() {
+		// abort handshakes in progress when the listener is closed
+		select {
+		case <-l.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	tlsConn := tls.Server(conn, l.cfg.TLSConfig)
+	authConn, err := l.auth.authorizeConn(ctx, tlsConn)
+	if err != nil {
+		if l.cfg.OnReject != nil {
+			l.cfg.OnReject(conn, err)
+		}
+		tlsConn.Close()
+		return
+	}
+	select {
+	case l.conns <- authConn:
+	case <-l.done:
+		tlsConn.Close()
+	}
+}
+
+// authorizeConn performs the handshake of a connection and authorizes its client
+func (a *Auth) authorizeConn(ctx context.Context, conn *tls.Conn) (*AuthenticatedConn, error) {
+	if err := conn.HandshakeContext(ctx); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	cs := conn.ConnectionState()
+	if err := validateConnectionState(&cs); err != nil {
+		a.auditConn(start, conn, nil, evaluation{group: -1, err: err})
+		a.observe(nil, err)
+		return nil, err
+	}
+
+	req := &AuthRequest{
+		Certificate:    cs.VerifiedChains[0][0],
+		VerifiedChains: cs.VerifiedChains,
+	}
+	res := a.authorize(ctx, req)
+	a.auditConn(start, conn, req, res)
+	a.observe(req.Certificate, res.err)
+	if res.err != nil {
+		return nil, res.err
+	}
+	return &AuthenticatedConn{Conn: conn, Identity: req.Identity, Claims: res.ctxParams}, nil
+}
+
+// auditConn emits the AuditEvent for a connection, if an AuditSink is configured
+func (a *Auth) auditConn(start time.Time, conn net.Conn, req *AuthRequest, res evaluation) {
+	if a.auditSink == nil {
+		return
+	}
+	ev := newAuditEvent(start, req, res)
+	ev.RemoteAddr = conn.RemoteAddr().String()
+	a.auditSink.Audit(context.Background(), ev)
+}
@@ -0,0 +1,85 @@
+package certauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/certauthtest"
+)
+
+func TestListener(t *testing.T) {
+	ca := certauthtest.NewCA(t)
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected := make(chan error, 1)
+	auth := certauth.New(certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)))
+	l := auth.NewListener(inner, certauth.ListenerConfig{
+		TLSConfig: ca.ServerConfig(t),
+		OnReject:  func(conn net.Conn, err error) { rejected <- err },
+	})
+	defer l.Close()
+
+	dial := func(opts certauthtest.Options) *tls.Conn {
+		t.Helper()
+		conn, err := tls.Dial("tcp", inner.Addr().String(), ca.ClientConfig(ca.IssueClient(t, opts)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+
+	// an unauthorized client is disconnected without being returned by Accept
+	denied := dial(certauthtest.Options{OrganizationalUnits: []string{"site"}, CommonName: "bar.com"})
+	defer denied.Close()
+	select {
+	case err := <-rejected:
+		var authErr *certauth.AuthorizationError
+		if !errors.As(err, &authErr) {
+			t.Errorf("expected an AuthorizationError, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the connection to be rejected")
+	}
+	denied.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := denied.Read(make([]byte, 1)); err == nil {
+		t.Error("expected the rejected connection to be closed")
+	}
+
+	allowed := dial(certauthtest.Options{OrganizationalUnits: []string{"endpoint"}, CommonName: "foo.com"})
+	defer allowed.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConn, ok := conn.(*certauth.AuthenticatedConn)
+	if !ok {
+		t.Fatalf("expected an AuthenticatedConn, got %T", conn)
+	}
+	expect(t, authConn.Identity.CommonName, "foo.com")
+	expect(t, authConn.Claims[certauth.HasAuthorizedOU].([]string)[0], "endpoint")
+	id, _ := certauth.IdentityFromContext(authConn.Context(context.Background()))
+	expect(t, id, authConn.Identity)
+
+	// the connection is usable once authorized
+	go authConn.Write([]byte("hi"))
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(allowed, buf); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, string(buf), "hi")
+	authConn.Close()
+
+	l.Close()
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected net.ErrClosed, got %v", err)
+	}
+}
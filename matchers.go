@@ -0,0 +1,206 @@
+package certauth
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Matcher reports whether a certificate field, such as an OU or CN, matches a pattern
+type Matcher interface {
+	Match(s string) bool
+	// String returns the pattern the Matcher was parsed from
+	String() string
+}
+
+// ParseMatcher compiles a pattern into a Matcher. A pattern without a recognised prefix is
+// compared exactly, otherwise the prefix selects the kind of match:
+//   - `exact:foo` matches `foo` only
+//   - `prefix:foo` matches values starting with `foo`
+//   - `suffix:foo` matches values ending with `foo`
+//   - `glob:*.foo` matches using shell-like wildcards: `*` matches any characters except `.`,
+//     `**` any characters including `.` and `?` any one character except `.`
+//   - `regex:fo+` matches the regular expression, which is anchored at both ends
+//
+// Each kind has a case-insensitive form with an `i` before the prefix, e.g. `iglob:*.foo`.
+func ParseMatcher(pattern string) (Matcher, error) {
+	kind, value, found := strings.Cut(pattern, ":")
+	if !found {
+		return exactMatcher{pattern}, nil
+	}
+	fold := strings.HasPrefix(kind, "i") && kind != "i"
+	base := kind
+	if fold {
+		base = kind[1:]
+	}
+
+	var expr string
+	switch base {
+	case "exact":
+		if !fold {
+			return exactMatcher{value}, nil
+		}
+		expr = regexp.QuoteMeta(value)
+	case "prefix":
+		if !fold {
+			return prefixMatcher{pattern, value}, nil
+		}
+		expr = regexp.QuoteMeta(value) + ".*"
+	case "suffix":
+		if !fold {
+			return suffixMatcher{pattern, value}, nil
+		}
+		expr = ".*" + regexp.QuoteMeta(value)
+	case "glob":
+		expr = globToRegexp(value)
+	case "regex":
+		// checked on its own so errors refer to the pattern as written
+		if _, err := regexp.Compile(value); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err.Error())
+		}
+		expr = value
+	default:
+		// not a prefix we know, e.g. a CN containing a colon
+		return exactMatcher{pattern}, nil
+	}
+
+	if fold {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %s", pattern, err.Error())
+	}
+	return regexpMatcher{pattern, re}, nil
+}
+
+// ParseMatchers compiles each of `patterns` with ParseMatcher
+func ParseMatchers(patterns []string) ([]Matcher, error) {
+	matchers := make([]Matcher, 0, len(patterns))
+	for _, pattern := range patterns {
+		m, err := ParseMatcher(pattern)
+		if err != nil {
+			return nil, err
+		}
+		matchers = append(matchers, m)
+	}
+	return matchers, nil
+}
+
+type exactMatcher struct{ value string }
+
+func (m exactMatcher) Match(s string) bool { return s == m.value }
+func (m exactMatcher) String() string      { return m.value }
+
+type prefixMatcher struct{ pattern, prefix string }
+
+func (m prefixMatcher) Match(s string) bool { return strings.HasPrefix(s, m.prefix) }
+func (m prefixMatcher) String() string      { return m.pattern }
+
+type suffixMatcher struct{ pattern, suffix string }
+
+func (m suffixMatcher) Match(s string) bool { return strings.HasSuffix(s, m.suffix) }
+func (m suffixMatcher) String() string      { return m.pattern }
+
+type regexpMatcher struct {
+	pattern string
+	re      *regexp.Regexp
+}
+
+func (m regexpMatcher) Match(s string) bool { return m.re.MatchString(s) }
+func (m regexpMatcher) String() string      { return m.pattern }
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case c == '*' && i+1 < len(glob) && glob[i+1] == '*':
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString(`[^.]*`)
+		case c == '?':
+			b.WriteString(`[^.]`)
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// AllowMatchingOUsandCNs is like AllowOUsandCNs, but compares the client's OUs and CN using the
+// Matchers parsed from `ouPatterns` and `cnPatterns` (see ParseMatcher) rather than `==`.
+// The patterns are compiled once, returning an error if any of them is invalid.
+func AllowMatchingOUsandCNs(ouPatterns, cnPatterns []string) (AuthorizationChecker, error) {
+	ous, err := ParseMatchers(ouPatterns)
+	if err != nil {
+		return nil, err
+	}
+	cns, err := ParseMatchers(cnPatterns)
+	if err != nil {
+		return nil, err
+	}
+	return MatchOUsandCNs{OUs: ous, CNs: cns}, nil
+}
+
+// WithMatchingOUsandCNs configures an Auth with a group holding the checker built by
+// AllowMatchingOUsandCNs, in the same way as WithCheckers. An invalid pattern causes New to
+// panic; use Build to handle it as an error.
+func WithMatchingOUsandCNs(ouPatterns, cnPatterns []string) AuthOption {
+	return func(a *Auth) {
+		ck, err := AllowMatchingOUsandCNs(ouPatterns, cnPatterns)
+		if err != nil {
+			a.errs = append(a.errs, err)
+			return
+		}
+		WithCheckers(ck)(a)
+	}
+}
+
+// MatchOUsandCNs is an AuthorizationChecker which behaves like AllowSpecificOUandCNs using
+// Matchers: the request is allowed if any of the client's OUs matches one of `OUs` *and* its CN
+// matches one of `CNs`. Empty lists disable checking that field.
+type MatchOUsandCNs struct {
+	OUs []Matcher
+	CNs []Matcher
+}
+
+func (m MatchOUsandCNs) CheckAuthorization(
+	clientOU []string, clientCN string,
+) (map[ContextKey]ContextValue, error) {
+	results := make(map[ContextKey]ContextValue)
+
+	if len(m.OUs) > 0 {
+		if !matchAny(m.OUs, clientOU...) {
+			return nil, &ReasonError{ReasonOUMismatch, fmt.Errorf(
+				"cert failed OU validation for %v, allowed: %v", clientOU, m.OUs)}
+		}
+		results[HasAuthorizedOU] = clientOU
+	}
+	if len(m.CNs) > 0 {
+		if !matchAny(m.CNs, clientCN) {
+			return nil, &ReasonError{ReasonCNMismatch, fmt.Errorf(
+				"cert failed CN validation for %q, allowed: %v", clientCN, m.CNs)}
+		}
+		results[HasAuthorizedCN] = clientCN
+	}
+	return results, nil
+}
+
+func (m MatchOUsandCNs) CheckAuthorizationWithParams(
+	clientOU []string, clientCN string, ps Params,
+) (map[ContextKey]ContextValue, error) {
+	// URI parameters are not handled separately, as with AllowSpecificOUandCNs
+	return m.CheckAuthorization(clientOU, clientCN)
+}
+
+func matchAny(matchers []Matcher, values ...string) bool {
+	for _, m := range matchers {
+		for _, v := range values {
+			if m.Match(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
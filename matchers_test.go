@@ -0,0 +1,92 @@
+package certauth_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+func TestParseMatcher(t *testing.T) {
+	tests := []struct {
+		Pattern  string
+		Value    string
+		ExpMatch bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "Foo", false},
+		{"exact:foo", "foo", true},
+		{"iexact:foo", "FOO", true},
+		{"urn:foo", "urn:foo", true},
+		{"prefix:worker-", "worker-12", true},
+		{"prefix:worker-", "db-12", false},
+		{"iprefix:worker-", "Worker-12", true},
+		{"suffix:.internal", "a.internal", true},
+		{"suffix:.internal", "a.external", false},
+		{"isuffix:.internal", "a.INTERNAL", true},
+		{"glob:*.worker.internal", "a1.worker.internal", true},
+		{"glob:*.worker.internal", "a.b.worker.internal", false},
+		{"glob:**.worker.internal", "a.b.worker.internal", true},
+		{"glob:worker-?", "worker-1", true},
+		{"glob:worker-?", "worker-12", false},
+		{"iglob:*.WORKER.internal", "a.worker.internal", true},
+		{"regex:worker-[0-9]+", "worker-12", true},
+		{"regex:worker-[0-9]+", "xworker-12", false},
+		{"regex:worker-[0-9]+", "worker-12x", false},
+		{"iregex:worker-[a-z]+", "WORKER-ab", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Pattern+" "+tc.Value, func(t2 *testing.T) {
+			m, err := certauth.ParseMatcher(tc.Pattern)
+			if err != nil {
+				t2.Fatal(err)
+			}
+			expect(t2, m.Match(tc.Value), tc.ExpMatch)
+		})
+	}
+
+	if _, err := certauth.ParseMatcher("regex:("); err == nil {
+		t.Error("expected an error for an invalid regex")
+	}
+}
+
+func TestMatchingOUsandCNs(t *testing.T) {
+	auth := certauth.New(
+		certauth.WithMatchingOUsandCNs([]string{"iexact:endpoint"}, []string{"glob:*.worker.internal"}),
+	)
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expect(t, r.Context().Value(certauth.HasAuthorizedCN), "a1.worker.internal")
+	}))
+
+	tests := []struct {
+		Name    string
+		OU      string
+		CN      string
+		ExpCode int
+	}{
+		{"Allowed", "Endpoint", "a1.worker.internal", http.StatusOK},
+		{"WrongOU", "site", "a1.worker.internal", http.StatusForbidden},
+		{"WrongCN", "endpoint", "db.internal", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "https://foo.bar/", nil)
+			req.TLS = &tls.ConnectionState{
+				VerifiedChains: fakeCertChain(fakeCertData{ou: []string{tc.OU}, cn: tc.CN}),
+			}
+			handler.ServeHTTP(w, req)
+			expect(t2, w.Code, tc.ExpCode)
+		})
+	}
+
+	ck, _ := certauth.AllowMatchingOUsandCNs(nil, []string{"prefix:worker-"})
+	_, err := ck.CheckAuthorization(nil, "db-1")
+	expectErr(t, err, mkCNErr("db-1", "prefix:worker-"))
+
+	if _, err := certauth.Build(certauth.WithMatchingOUsandCNs([]string{"regex:["}, nil)); err == nil {
+		t.Error("expected Build to fail for an invalid pattern")
+	}
+}
@@ -0,0 +1,46 @@
+package certauth
+
+import (
+	"crypto/x509"
+	"time"
+)
+
+// Metrics receives measurements of the requests processed by an Auth. Its methods are called
+// synchronously on the request path so implementations should be quick and safe for concurrent
+// use. See the promtext package for an implementation exposing them in the Prometheus text
+// format.
+type Metrics interface {
+	// ObserveRequest is called once per request with its outcome. `reason` is "" for allowed
+	// requests, otherwise it classifies the denial, see ReasonFor.
+	ObserveRequest(allowed bool, reason string)
+
+	// ObserveChecker is called after each checker runs with the time it took. `route` is the
+	// pattern of the route the checker belongs to, "" for the default checkers, and `group` and
+	// `checker` are the indexes of the group and of the checker within it.
+	ObserveChecker(route string, group, checker int, latency time.Duration)
+
+	// ObserveCertificate is called with the leaf certificate of every request which presented a
+	// verified client certificate
+	ObserveCertificate(cert *x509.Certificate)
+}
+
+// WithMetrics configures an Auth to report the outcome of every request processed by its
+// Handler, RouterHandler, Process, ProcessWithParams and AuthorizeRequest to `m`. Checkers run
+// by Authorize, e.g. through CheckAuthorization, are timed but their outcomes are not counted.
+func WithMetrics(m Metrics) AuthOption {
+	return func(a *Auth) {
+		a.metrics = m
+	}
+}
+
+// observe reports the outcome of a request. `cert` is nil when the request failed validation
+// before a client certificate was found.
+func (a *Auth) observe(cert *x509.Certificate, err error) {
+	if a.metrics == nil {
+		return
+	}
+	if cert != nil {
+		a.metrics.ObserveCertificate(cert)
+	}
+	a.metrics.ObserveRequest(err == nil, ReasonFor(err))
+}
@@ -0,0 +1,116 @@
+package certauth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+type recordedChecker struct {
+	route          string
+	group, checker int
+}
+
+type recordingMetrics struct {
+	mu       sync.Mutex
+	reasons  []string
+	checkers []recordedChecker
+	certs    []*x509.Certificate
+}
+
+func (m *recordingMetrics) ObserveRequest(allowed bool, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if allowed {
+		reason = "allowed"
+	}
+	m.reasons = append(m.reasons, reason)
+}
+
+func (m *recordingMetrics) ObserveChecker(route string, group, checker int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.checkers = append(m.checkers, recordedChecker{route, group, checker})
+}
+
+func (m *recordingMetrics) ObserveCertificate(cert *x509.Certificate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.certs = append(m.certs, cert)
+}
+
+func TestMetrics(t *testing.T) {
+	m := &recordingMetrics{}
+	auth := certauth.New(
+		certauth.WithRouteCheckers("/admin/",
+			certauth.AllowOUsandCNs([]string{"endpoint"}, nil),
+			certauth.AllowOUsandCNs(nil, []string{"admin"}),
+		),
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		certauth.WithMetrics(m),
+	)
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	admin := fakeCertChain(fakeCertData{[]string{"endpoint"}, "admin"})
+	other := fakeCertChain(fakeCertData{[]string{"endpoint"}, "other"})
+	site := fakeCertChain(fakeCertData{[]string{"site"}, "other"})
+	tests := []struct {
+		Name        string
+		Path        string
+		TLS         *tls.ConnectionState
+		ExpReason   string
+		ExpCheckers []recordedChecker
+	}{
+		{"NoCert", "/", nil, certauth.ReasonNoClientCert, nil},
+		{
+			"ChainMismatch", "/",
+			&tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{{Raw: []byte("a")}},
+				VerifiedChains:   [][]*x509.Certificate{{{Raw: []byte("b")}}},
+			},
+			certauth.ReasonChainMismatch, nil,
+		},
+		{
+			"OUMismatch", "/", &tls.ConnectionState{VerifiedChains: site},
+			certauth.ReasonOUMismatch, []recordedChecker{{"", 0, 0}},
+		},
+		{
+			"CNMismatch", "/admin/", &tls.ConnectionState{VerifiedChains: other},
+			certauth.ReasonCNMismatch, []recordedChecker{{"/admin/", 0, 0}, {"/admin/", 0, 1}},
+		},
+		{
+			"Allowed", "/admin/", &tls.ConnectionState{VerifiedChains: admin},
+			"allowed", []recordedChecker{{"/admin/", 0, 0}, {"/admin/", 0, 1}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			*m = recordingMetrics{}
+			req := httptest.NewRequest("GET", "https://foo.bar"+tc.Path, nil)
+			req.TLS = tc.TLS
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			expect(t2, fmt.Sprint(m.reasons), fmt.Sprint([]string{tc.ExpReason}))
+			expect(t2, fmt.Sprint(m.checkers), fmt.Sprint(tc.ExpCheckers))
+			// the certificate is observed once it has been validated
+			expect(t2, len(m.certs), min(len(tc.ExpCheckers), 1))
+		})
+	}
+}
+
+func TestReasonFor(t *testing.T) {
+	expect(t, certauth.ReasonFor(nil), "")
+	expect(t, certauth.ReasonFor(errRevoked), certauth.ReasonDenied)
+	expect(t, certauth.ReasonFor(certauth.ErrNoRoute), certauth.ReasonNoRoute)
+	expect(t, certauth.ReasonFor(&certauth.AuthorizationError{Failures: []certauth.CheckerFailure{
+		{Err: &certauth.ReasonError{Reason: certauth.ReasonOUMismatch, Err: errRevoked}},
+		{Err: errors.Join(errRevoked, &certauth.ReasonError{Reason: "revoked", Err: errRevoked})},
+	}}), "revoked")
+}
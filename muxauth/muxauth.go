@@ -0,0 +1,38 @@
+// Package muxauth adapts a certauth.Auth as github.com/gorilla/mux middleware.
+//
+//	r := mux.NewRouter()
+//	r.Use(muxauth.Middleware(auth))
+//	r.HandleFunc("/sites/{site}", handler)
+package muxauth
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// Middleware returns mux middleware authorizing requests with `a`, passing the route variables of
+// the matched route to its checkers. mux matches the route before running the middleware added
+// with Router.Use, so the variables are available.
+func Middleware(a *certauth.Auth) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var err error
+			if r, err = a.ProcessWithParams(w, r, Params(r)); err != nil {
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Params returns the route variables mux captured for the request, or nil if it has none
+func Params(r *http.Request) certauth.Params {
+	vars := mux.Vars(r)
+	if len(vars) == 0 {
+		return nil
+	}
+	return certauth.ParamsMap(vars)
+}
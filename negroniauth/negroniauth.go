@@ -0,0 +1,29 @@
+// Package negroniauth adapts a certauth.Auth as github.com/urfave/negroni middleware.
+//
+//	n := negroni.New()
+//	n.Use(negroniauth.Middleware(auth))
+//	n.UseHandler(mux)
+//
+// Negroni runs its middleware before the router, so there are no route params to pass to the
+// checkers. Configure the Auth with WithRoute for checkers which depend on them: the params are
+// then captured by the route patterns.
+package negroniauth
+
+import (
+	"net/http"
+
+	"github.com/urfave/negroni"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// Middleware returns negroni middleware authorizing requests with `a`
+func Middleware(a *certauth.Auth) negroni.Handler {
+	return negroni.HandlerFunc(func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		var err error
+		if r, err = a.Process(w, r); err != nil {
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,116 @@
+// Package oteltracing implements certauth.Tracer with OpenTelemetry, so that the authorization of
+// each request shows up as a span in its trace.
+//
+//	auth := certauth.New(
+//		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+//		certauth.WithTracer(oteltracing.New(nil)),
+//	)
+//
+// The span is a child of the span found in the request's context, e.g. one started by otelhttp,
+// and is named "certauth.ProcessWithParams". It records the client's subject and serial, the
+// checker group which passed and the denial reason as attributes, and the result of each checker
+// as a "certauth.checker" event.
+package oteltracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// ScopeName is the instrumentation scope of the tracer
+const ScopeName = "github.com/pantheon-systems/go-certauth/oteltracing"
+
+// SpanName is the name of the spans
+const SpanName = "certauth.ProcessWithParams"
+
+// Attribute keys set on the spans
+const (
+	AllowedKey = attribute.Key("certauth.allowed")
+	SubjectKey = attribute.Key("certauth.subject")
+	IssuerKey  = attribute.Key("certauth.issuer")
+	SerialKey  = attribute.Key("certauth.serial")
+	// GroupKey is the index of the checker group which passed
+	GroupKey = attribute.Key("certauth.group")
+	// ReasonKey classifies the denial, see certauth.ReasonFor
+	ReasonKey = attribute.Key("certauth.reason")
+	ErrorKey  = attribute.Key("certauth.error")
+	CachedKey = attribute.Key("certauth.cached")
+
+	// Attributes of the checker events
+	RouteKey   = attribute.Key("certauth.route")
+	CheckerKey = attribute.Key("certauth.checker")
+)
+
+// Tracer is a certauth.Tracer starting OpenTelemetry spans
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// New returns a Tracer using `tp`, or the global TracerProvider if nil. The global provider is a
+// no-op until one is registered with otel.SetTracerProvider.
+func New(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &Tracer{tracer: tp.Tracer(ScopeName)}
+}
+
+// Start implements certauth.Tracer
+func (t *Tracer) Start(ctx context.Context, r *http.Request) (context.Context, certauth.Span) {
+	ctx, span := t.tracer.Start(ctx, SpanName, trace.WithSpanKind(trace.SpanKindInternal))
+	return ctx, &spanAdapter{span: span}
+}
+
+type spanAdapter struct {
+	span trace.Span
+}
+
+// CheckerResult implements certauth.Span
+func (s *spanAdapter) CheckerResult(route string, group, checker int, err error) {
+	if !s.span.IsRecording() {
+		return
+	}
+	attrs := []attribute.KeyValue{
+		RouteKey.String(route),
+		GroupKey.Int(group),
+		CheckerKey.Int(checker),
+		AllowedKey.Bool(err == nil),
+	}
+	if err != nil {
+		attrs = append(attrs, ReasonKey.String(certauth.ReasonFor(err)), ErrorKey.String(err.Error()))
+	}
+	s.span.AddEvent("certauth.checker", trace.WithAttributes(attrs...))
+}
+
+// End implements certauth.Span
+func (s *spanAdapter) End(ev certauth.AuditEvent, err error) {
+	defer s.span.End()
+	if !s.span.IsRecording() {
+		return
+	}
+	attrs := []attribute.KeyValue{AllowedKey.Bool(ev.Allowed)}
+	if ev.Route != "" {
+		attrs = append(attrs, RouteKey.String(ev.Route))
+	}
+	if ev.Subject != "" {
+		attrs = append(attrs, SubjectKey.String(ev.Subject), IssuerKey.String(ev.Issuer), SerialKey.String(ev.Serial))
+	}
+	for _, g := range ev.Groups {
+		if g.Allowed {
+			attrs = append(attrs, GroupKey.Int(g.Group))
+		}
+	}
+	if err != nil {
+		attrs = append(attrs, ReasonKey.String(certauth.ReasonFor(err)), ErrorKey.String(err.Error()))
+	}
+	if ev.Cached {
+		attrs = append(attrs, CachedKey.Bool(true))
+	}
+	s.span.SetAttributes(attrs...)
+}
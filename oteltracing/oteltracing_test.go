@@ -0,0 +1,157 @@
+package oteltracing_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/oteltracing"
+)
+
+func expect(t *testing.T, actual interface{}, expected interface{}) {
+	t.Helper()
+	if actual != expected {
+		t.Errorf("Expected [%v] (type %T) - Got [%v] (type %T)", expected, expected, actual, actual)
+	}
+}
+
+func clientChain(ou, cn string) [][]*x509.Certificate {
+	return [][]*x509.Certificate{{{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{OrganizationalUnit: []string{ou}, CommonName: cn},
+		Issuer:       pkix.Name{CommonName: "ca"},
+	}}}
+}
+
+func attrs(kvs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	m := make(map[attribute.Key]attribute.Value, len(kvs))
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value
+	}
+	return m
+}
+
+func TestTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	auth := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs(nil, []string{"admin"})),
+		certauth.WithCheckers(
+			certauth.AllowOUsandCNs([]string{"endpoint"}, nil),
+			certauth.AllowOUsandCNs(nil, []string{"foo.com"}),
+		),
+		certauth.WithTracer(oteltracing.New(tp)),
+	)
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		Name       string
+		Chains     [][]*x509.Certificate
+		ExpAllowed bool
+		ExpReason  string
+		ExpGroup   int64
+		ExpEvents  int
+	}{
+		{"Allowed", clientChain("endpoint", "foo.com"), true, "", 1, 3},
+		{"CNMismatch", clientChain("endpoint", "bar.com"), false, certauth.ReasonCNMismatch, -1, 3},
+		{"OUMismatch", clientChain("site", "foo.com"), false, certauth.ReasonOUMismatch, -1, 2},
+		{"NoCert", nil, false, certauth.ReasonNoClientCert, -1, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			exporter.Reset()
+			parentCtx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+			req := httptest.NewRequest("GET", "https://foo.bar/", nil).WithContext(parentCtx)
+			if tc.Chains != nil {
+				req.TLS = &tls.ConnectionState{VerifiedChains: tc.Chains}
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			parent.End()
+
+			spans := exporter.GetSpans()
+			expect(t2, len(spans), 2)
+			span := spans[0]
+			expect(t2, span.Name, oteltracing.SpanName)
+			expect(t2, span.Parent.SpanID(), parent.SpanContext().SpanID())
+			expect(t2, span.SpanContext.TraceID(), parent.SpanContext().TraceID())
+
+			a := attrs(span.Attributes)
+			expect(t2, a[oteltracing.AllowedKey].AsBool(), tc.ExpAllowed)
+			expect(t2, a[oteltracing.ReasonKey].AsString(), tc.ExpReason)
+			if group, ok := a[oteltracing.GroupKey]; ok {
+				expect(t2, group.AsInt64(), tc.ExpGroup)
+			} else {
+				expect(t2, int64(-1), tc.ExpGroup)
+			}
+			if tc.Chains != nil {
+				expect(t2, a[oteltracing.SubjectKey].AsString(), tc.Chains[0][0].Subject.String())
+				expect(t2, a[oteltracing.SerialKey].AsString(), "2a") // the Identity formats serials in hex
+			}
+
+			expect(t2, len(span.Events), tc.ExpEvents)
+			for _, ev := range span.Events {
+				expect(t2, ev.Name, "certauth.checker")
+			}
+			if tc.ExpEvents > 0 {
+				// the first group's only checker denies everyone but admin
+				first := attrs(span.Events[0].Attributes)
+				expect(t2, first[oteltracing.GroupKey].AsInt64(), int64(0))
+				expect(t2, first[oteltracing.CheckerKey].AsInt64(), int64(0))
+				expect(t2, first[oteltracing.AllowedKey].AsBool(), false)
+				expect(t2, first[oteltracing.ReasonKey].AsString(), certauth.ReasonCNMismatch)
+			}
+		})
+	}
+}
+
+// TestCheckerSpans checks that spans started by checkers are children of the certauth span
+func TestCheckerSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	auth := certauth.New(
+		certauth.WithRequestCheckers(certauth.RequestCheckerFunc(
+			func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+				_, span := tp.Tracer("test").Start(ctx, "lookup")
+				span.End()
+				return certauth.Allow(nil), nil
+			},
+		)),
+		certauth.WithTracer(oteltracing.New(tp)),
+	)
+
+	var handlerCtx context.Context
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerCtx = r.Context()
+	}))
+	req := httptest.NewRequest("GET", "https://foo.bar/", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: clientChain("endpoint", "foo.com")}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := exporter.GetSpans()
+	expect(t, len(spans), 2)
+	expect(t, spans[0].Name, "lookup")
+	expect(t, spans[0].Parent.SpanID(), spans[1].SpanContext.SpanID())
+	// the handler does not run within the certauth span
+	_, handlerSpan := tp.Tracer("test").Start(handlerCtx, "handler")
+	expect(t, handlerSpan.(sdktrace.ReadOnlySpan).Parent().IsValid(), false)
+}
+
+func TestNoopDefault(t *testing.T) {
+	auth := certauth.New(certauth.WithTracer(oteltracing.New(nil)))
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "https://foo.bar/", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: clientChain("endpoint", "foo.com")}
+	handler.ServeHTTP(w, req)
+	expect(t, w.Code, http.StatusOK)
+}
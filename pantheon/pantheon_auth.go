@@ -1,11 +1,11 @@
 package pantheon_auth
 
 import (
+	"context"
 	"fmt"
 	"strings"
 
 	"github.com/google/uuid"
-	"github.com/julienschmidt/httprouter"
 
 	"github.com/pantheon-systems/go-certauth"
 )
@@ -17,6 +17,12 @@ func (c contextKey) String() string {
 	return "pantheon context " + string(c)
 }
 
+// HeaderName implements certauth.HeaderKey so the site and env are added to the request headers
+// when certauth header injection is enabled, e.g. `X-Client-Cert-Pantheon-Site`.
+func (c contextKey) HeaderName() string {
+	return strings.ReplaceAll(string(c), " ", "-")
+}
+
 const (
 	// PantheonSite is used as the request context key identifying the client's Site (if present)
 	PantheonSite = contextKey("Pantheon Site")
@@ -26,6 +32,24 @@ const (
 	PantheonEnv = contextKey("Pantheon Env")
 )
 
+// ReasonSiteMismatch classifies requests denied because the client's site does not match the
+// requested site, see certauth.ReasonFor
+const ReasonSiteMismatch = "site_mismatch"
+
+// SiteFromContext returns the client's site added to the request context by
+// PantheonSiteAuthChecker
+func SiteFromContext(ctx context.Context) (string, bool) {
+	site, ok := ctx.Value(PantheonSite).(string)
+	return site, ok
+}
+
+// EnvFromContext returns the client's environment added to the request context by
+// PantheonSiteAuthChecker
+func EnvFromContext(ctx context.Context) (string, bool) {
+	env, ok := ctx.Value(PantheonEnv).(string)
+	return env, ok
+}
+
 // Helper function which produces AuthorizationCheckers suitable for use in Pantheon HTTP servers.
 // This function accepts three lists which determine which clients pass authorization checks
 // and produces 2 AuthorizationCheckers to implement these checks.
@@ -43,7 +67,8 @@ const (
 // For example: if site A makes a request for information belonging to site B, that request should
 // fail the site authorization check.
 //
-// The way this works hinges on the use of URI parameters with the `httprouter` framework.
+// The way this works hinges on the use of URI parameters, see certauth.Params for the routers
+// they may come from.
 // Essentially, the server can define certain URIs as being site-specific by adding a `site` URI
 // parameter. The site authorization check then compares the `site` URI parameter with the `site`
 // determined from the client certificate's CommonName. If they match, then the request is allowed.
@@ -54,7 +79,8 @@ const (
 // authorization check should be run for.
 //
 // In order for site authorization checks to be run, a few things must be true:
-// 1. The server must be using the `httprouter` framework.
+// 1. The server must pass the route params to the Auth, e.g. with RouterHandler, an http.ServeMux
+// or one of the router adapter packages.
 // 2. The server must define the `site` URI parameter in the URI path.
 // 3. The request must be performed against one of the URIs with the `site` parameter.
 // 4. At least one of the request's OUs must be present in the `siteOUs` option of `PantheonSiteAuth`
@@ -81,19 +107,25 @@ type PantheonSiteAuthChecker struct {
 	AllowSelf bool
 }
 
+// HeaderKeys implements certauth.HeaderKeyProvider so client supplied site and env headers are
+// removed even from requests site authorization doesn't apply to
+func (check PantheonSiteAuthChecker) HeaderKeys() []certauth.HeaderKey {
+	return []certauth.HeaderKey{PantheonSite, PantheonEnv}
+}
+
 func (check PantheonSiteAuthChecker) CheckAuthorization(
 	clientOU []string, clientCN string,
 ) (map[certauth.ContextKey]certauth.ContextValue, error) {
-	// Site authorization does not apply to this request because the server
-	// is not using the `httprouter` framework.
+	// Site authorization does not apply to this request because it has
+	// no route params.
 
 	// TODO(zeal): Maybe fail here since we expect all pantheon HTTP servers to be using
-	//             `httprouter`?
+	//             route params?
 	return nil, nil
 }
 
 func (check PantheonSiteAuthChecker) CheckAuthorizationWithParams(
-	clientOU []string, clientCN string, ps httprouter.Params,
+	clientOU []string, clientCN string, ps certauth.Params,
 ) (map[certauth.ContextKey]certauth.ContextValue, error) {
 	if !checkOUMembership(check.SiteOUs, clientOU) {
 		// Site authorization does not apply to this request because
@@ -120,11 +152,11 @@ func (check PantheonSiteAuthChecker) CheckAuthorizationWithParams(
 	}
 
 	if certSite != uriSite {
-		return nil, fmt.Errorf(
+		return nil, &certauth.ReasonError{Reason: ReasonSiteMismatch, Err: fmt.Errorf(
 			"site %q is not authorized to requests for site %q",
 			certSite,
 			uriSite,
-		)
+		)}
 	}
 
 	return prepareSiteContextParams(certSite, certEnv), nil
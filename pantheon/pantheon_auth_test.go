@@ -1,3 +1,6 @@
+// ServeMux patterns are off by default for go 1.21 modules
+//go:debug httpmuxgo121=0
+
 package pantheon_auth_test
 
 import (
@@ -256,3 +259,108 @@ func TestParsesCNProperly(t *testing.T) {
 		})
 	}
 }
+
+func TestSiteHeaders(t *testing.T) {
+	auth := certauth.New(
+		certauth.WithCheckers(pantheon_auth.PantheonSiteAuth([]string{"site"}, []string{"site"}, false)...),
+		certauth.WithHeaders(),
+	)
+
+	site := "00c66762-d8ac-450b-b368-459c5d4f6aab"
+	var siteHdr, envHdr, siteCtx, envCtx string
+	rtr := httprouter.New()
+	rtr.GET("/site_test/:site", auth.RouterHandler(httprouter.Handle(
+		func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			siteHdr = r.Header.Get("X-Client-Cert-Pantheon-Site")
+			envHdr = r.Header.Get("X-Client-Cert-Pantheon-Env")
+			siteCtx, _ = pantheon_auth.SiteFromContext(r.Context())
+			envCtx, _ = pantheon_auth.EnvFromContext(r.Context())
+		},
+	)))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "https://foo.bar/site_test/"+site, nil)
+	req.Header.Set("X-Client-Cert-Pantheon-Env", "live")
+	req.TLS = &tls.ConnectionState{}
+	req.TLS.VerifiedChains = makeFakeCert("site", fmt.Sprintf("dev.%s.foo.com", site))
+
+	rtr.ServeHTTP(w, req)
+	expect(t, w.Code, http.StatusOK)
+	expect(t, siteHdr, site)
+	expect(t, envHdr, "dev")
+	expect(t, siteCtx, site)
+	expect(t, envCtx, "dev")
+
+	_, ok := pantheon_auth.SiteFromContext(req.Context())
+	expect(t, ok, false)
+}
+
+func TestSiteHeadersWithoutPrefix(t *testing.T) {
+	auth := certauth.New(
+		certauth.WithCheckers(pantheon_auth.PantheonSiteAuth([]string{"site", "backend"}, []string{"site"}, false)...),
+		certauth.WithHeaderPrefix(""),
+	)
+
+	var siteHdr string
+	rtr := httprouter.New()
+	rtr.GET("/site_test/:site", auth.RouterHandler(httprouter.Handle(
+		func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			siteHdr = r.Header.Get("Pantheon-Site")
+		},
+	)))
+
+	// site authorization doesn't apply to backend clients, the spoofed header is removed anyway
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "https://foo.bar/site_test/00c66762-d8ac-450b-b368-459c5d4f6aab", nil)
+	req.Header.Set("Pantheon-Site", "victim")
+	req.TLS = &tls.ConnectionState{}
+	req.TLS.VerifiedChains = makeFakeCert("backend", "foo.com")
+
+	rtr.ServeHTTP(w, req)
+	expect(t, w.Code, http.StatusOK)
+	expect(t, siteHdr, "")
+}
+
+func TestSiteMismatchReason(t *testing.T) {
+	auth := certauth.New(certauth.WithCheckers(
+		pantheon_auth.PantheonSiteAuth([]string{"site"}, []string{"site"}, false)...,
+	))
+	site1 := "00c66762-d8ac-450b-b368-459c5d4f6aab"
+	cert := makeFakeCert("site", "dev."+site1+".foo.com")[0][0]
+
+	_, err := auth.CheckAuthorization(cert, httprouter.Params{{Key: "site", Value: site1}})
+	expectErr(t, err, nil)
+	_, err = auth.CheckAuthorization(cert, httprouter.Params{{Key: "site", Value: "other"}})
+	expect(t, certauth.ReasonFor(err), pantheon_auth.ReasonSiteMismatch)
+}
+
+func TestSiteAuthorizationServeMux(t *testing.T) {
+	// site authorization works with the path values of http.ServeMux, as with httprouter
+	auth := certauth.New(certauth.WithCheckers(
+		pantheon_auth.PantheonSiteAuth([]string{"site"}, []string{"site"}, false)...,
+	))
+	mux := http.NewServeMux()
+	mux.Handle("GET /sites/{site}/", auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site, _ := pantheon_auth.SiteFromContext(r.Context())
+		fmt.Fprint(w, site)
+	})))
+
+	site1 := "00c66762-d8ac-450b-b368-459c5d4f6aab"
+	site2 := "1fab8f7f-b5cc-411d-abed-7432dd62af60"
+	for _, tc := range []struct {
+		Site    string
+		ExpCode int
+	}{
+		{site1, http.StatusOK},
+		{site2, http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "https://foo.bar/sites/"+tc.Site+"/info", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: makeFakeCert("site", fmt.Sprintf("dev.%s.foo.com", site1))}
+		mux.ServeHTTP(w, req)
+		expect(t, w.Code, tc.ExpCode)
+		if tc.ExpCode == http.StatusOK {
+			expect(t, w.Body.String(), site1)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package certauth
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Params are the route params of a request, e.g. the `site` of `/sites/:site`, which checkers
+// may use to decide whether the client is authorized to the requested resource. Any router's
+// params can be used:
+//   - httprouter.Params implement Params
+//   - PathValues returns the path values of a request routed by http.ServeMux
+//   - ParamsMap holds params in a map, e.g. gorilla/mux's mux.Vars(r)
+//   - the chiauth, echoauth, ginauth and muxauth packages return the params of their router
+type Params interface {
+	// ByName returns the value of the param `name`, or "" if there is none
+	ByName(name string) string
+}
+
+// ParamsMap is a map of param names to values implementing Params
+type ParamsMap map[string]string
+
+// ByName implements Params
+func (m ParamsMap) ByName(name string) string {
+	return m[name]
+}
+
+// pathValues are the path values of a request routed by an http.ServeMux, see PathValues
+type pathValues struct {
+	r     *http.Request
+	names []string
+}
+
+// normalizeParams returns nil for nil params held in a non-nil interface, e.g. the nil
+// httprouter.Params of a route without params, so they are treated as no params
+func normalizeParams(ps Params) Params {
+	switch v := ps.(type) {
+	case httprouter.Params:
+		if v == nil {
+			return nil
+		}
+	case ParamsMap:
+		if v == nil {
+			return nil
+		}
+	}
+	return ps
+}
+
+// paramPairs returns the names and values of `ps` in a stable order, or false if they can't be
+// enumerated
+func paramPairs(ps Params) ([][2]string, bool) {
+	var pairs [][2]string
+	switch v := ps.(type) {
+	case nil:
+	case httprouter.Params:
+		for _, p := range v {
+			pairs = append(pairs, [2]string{p.Key, p.Value})
+		}
+	case ParamsMap:
+		for k, val := range v {
+			pairs = append(pairs, [2]string{k, val})
+		}
+		sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	case pathValues:
+		for _, name := range v.names {
+			pairs = append(pairs, [2]string{name, v.ByName(name)})
+		}
+	default:
+		return nil, false
+	}
+	return pairs, true
+}
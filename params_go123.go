@@ -0,0 +1,33 @@
+//go:build go1.23
+
+package certauth
+
+import "net/http"
+
+// PathValues returns the path values of a request routed by an http.ServeMux (Go 1.23+) as
+// Params, or nil if the request was not routed by one. Auth uses them when a request has no
+// other params, so checkers see the path values of a handler wrapped with Handler and registered
+// on an http.ServeMux.
+func PathValues(r *http.Request) Params {
+	if r == nil || r.Pattern == "" {
+		return nil
+	}
+	p, err := ParseRoutePattern(r.Pattern)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, seg := range p.segments {
+		if seg.param != "" {
+			names = append(names, seg.param)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return pathValues{r: r, names: names}
+}
+
+func (p pathValues) ByName(name string) string {
+	return p.r.PathValue(name)
+}
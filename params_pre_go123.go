@@ -0,0 +1,15 @@
+//go:build !go1.23
+
+package certauth
+
+import "net/http"
+
+// PathValues returns nil: http.Request.Pattern, which names the path values of a request routed
+// by an http.ServeMux, needs Go 1.23
+func PathValues(r *http.Request) Params {
+	return nil
+}
+
+func (p pathValues) ByName(name string) string {
+	return ""
+}
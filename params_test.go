@@ -0,0 +1,88 @@
+// ServeMux patterns are off by default for go 1.21 modules
+//go:debug httpmuxgo121=0
+
+package certauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// siteParamChecker allows clients whose CN is the `site` param, recording the params it was given
+type siteParamChecker struct {
+	seen certauth.Params
+}
+
+func (c *siteParamChecker) Check(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+	c.seen = req.Params
+	if req.Params == nil || req.Params.ByName("site") != req.Identity.CommonName {
+		return certauth.Deny("wrong site"), nil
+	}
+	return certauth.Allow(nil), nil
+}
+
+func TestParamsSources(t *testing.T) {
+	checker := &siteParamChecker{}
+	auth := certauth.New(certauth.WithRequestCheckers(checker))
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /sites/{site}/envs/{env}", auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	mux.Handle("GET /other", auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	router := httprouter.New()
+	router.GET("/sites/:site", auth.RouterHandler(func(http.ResponseWriter, *http.Request, httprouter.Params) {}))
+	router.GET("/nosite", auth.RouterHandler(func(http.ResponseWriter, *http.Request, httprouter.Params) {}))
+
+	tests := []struct {
+		Name      string
+		Handler   http.Handler
+		Path      string
+		ExpCode   int
+		ExpParams bool
+	}{
+		{"ServeMux", mux, "/sites/foo/envs/dev", http.StatusOK, true},
+		{"ServeMuxWrongSite", mux, "/sites/bar/envs/dev", http.StatusForbidden, true},
+		{"ServeMuxNoParams", mux, "/other", http.StatusForbidden, false},
+		{"HTTPRouter", router, "/sites/foo", http.StatusOK, true},
+		{"HTTPRouterWrongSite", router, "/sites/bar", http.StatusForbidden, true},
+		// httprouter passes nil params, which are not passed on as a non-nil Params
+		{"HTTPRouterNoParams", router, "/nosite", http.StatusForbidden, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			checker.seen = nil
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "https://foo.bar"+tc.Path, nil)
+			req.TLS = &tls.ConnectionState{VerifiedChains: fakeCertChain(fakeCertData{cn: "foo"})}
+			tc.Handler.ServeHTTP(w, req)
+			expect(t2, w.Code, tc.ExpCode)
+			expect(t2, checker.seen != nil, tc.ExpParams)
+		})
+	}
+}
+
+func TestParamsMap(t *testing.T) {
+	checker := &countingChecker{allowed: map[string]bool{"foo.com": true}}
+	auth := certauth.New(
+		certauth.WithRequestCheckers(checker),
+		certauth.WithDecisionCache(certauth.CacheConfig{}),
+	)
+	cert := fakeCertChain(fakeCertData{cn: "foo.com"})[0][0]
+	cert.Raw = []byte("foo.com")
+
+	var ps certauth.ParamsMap
+	expect(t, ps.ByName("site"), "")
+	for _, ps := range []certauth.ParamsMap{{"site": "a", "env": "dev"}, {"env": "dev", "site": "a"}, {"site": "b"}} {
+		_, err := auth.CheckAuthorization(cert, ps)
+		expectErr(t, err, nil)
+	}
+	// maps with the same params share a cache key regardless of their order
+	expect(t, checker.calls.Load(), int64(2))
+}
@@ -0,0 +1,561 @@
+// Package policy loads certauth authorization rules from a declarative YAML or JSON file, so who
+// may access which routes can be changed without rebuilding the server.
+//
+// A policy is a list of rules. Each rule is scoped to some methods and path patterns and lists
+// the groups of clients it allows. A group allows a client when all of its matchers pass (AND),
+// and a request is allowed when any group of any rule whose scope includes it passes (OR):
+//
+//	version: 1
+//	rules:
+//	  - name: site-api
+//	    methods: [GET, POST]
+//	    paths: ["/sites/:site/**"]
+//	    allow:
+//	      - ou: [site]
+//	        pantheon_site: {site_ous: [site], allow_self: true}
+//	      - ou: [backend]
+//	        cn: [dashboard.example.com]
+//	  - name: health
+//	    paths: [/health]
+//	    allow:
+//	      - any: true
+//
+// Rules without methods or paths apply to every method or path. Paths use the syntax of
+// certauth.RoutePattern, without a method or host; a request's path must match them both escaped
+// and unescaped, so `/a%2Fb` is not allowed by a rule for `/{name}`. Params captured by the
+// pattern are passed to the checkers of requests which don't already have route params, e.g.
+// those not using the `httprouter` framework.
+package policy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/yaml.v3"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/pantheon"
+)
+
+// Version is the policy file format version understood by this package
+const Version = 1
+
+// Policy is a parsed and validated policy file
+type Policy struct {
+	Version int    `yaml:"version"`
+	Rules   []Rule `yaml:"rules"`
+
+	groups [][]certauth.RequestChecker
+}
+
+// Rule allows groups of clients to access the requests matching its methods and paths
+type Rule struct {
+	// Name identifies the rule in errors, defaults to `rule N`
+	Name string `yaml:"name"`
+	// Methods the rule applies to, all methods if empty. GET also covers HEAD.
+	Methods []string `yaml:"methods"`
+	// Paths are the path patterns the rule applies to, all paths if empty
+	Paths []string `yaml:"paths"`
+	// Allow lists the groups of clients allowed by the rule
+	Allow []Group `yaml:"allow"`
+
+	node     *yaml.Node
+	patterns []*certauth.RoutePattern
+}
+
+// Group allows clients matching all of its fields
+type Group struct {
+	// OU allows clients with any of these Organizational Units. Entries are patterns, see
+	// certauth.ParseMatcher.
+	OU []string `yaml:"ou"`
+	// CN allows clients with any of these Common Names. Entries are patterns, see
+	// certauth.ParseMatcher.
+	CN []string `yaml:"cn"`
+	// SAN allows clients with a Subject Alternative Name listed for each of its non-empty fields
+	SAN *SANMatch `yaml:"san"`
+	// PantheonSite applies Pantheon site authorization, see pantheon_auth.PantheonSiteAuth. It
+	// requires OU, which plays the part of PantheonSiteAuth's allowedOUs: the site check alone
+	// passes every client without one of the site OUs.
+	PantheonSite *SiteRule `yaml:"pantheon_site"`
+	// Any allows every client with a verified certificate. It can't be combined with other fields.
+	Any bool `yaml:"any"`
+
+	node     *yaml.Node
+	ouAndCNs certauth.MatchOUsandCNs
+}
+
+// SANMatch lists the allowed Subject Alternative Names of each type
+type SANMatch struct {
+	DNS   []string `yaml:"dns"`
+	URI   []string `yaml:"uri"`
+	Email []string `yaml:"email"`
+	// IP entries are addresses or CIDR ranges
+	IP []string `yaml:"ip"`
+
+	ipNets []*net.IPNet
+}
+
+// SiteRule configures a pantheon_auth.PantheonSiteAuthChecker
+type SiteRule struct {
+	SiteOUs   []string `yaml:"site_ous"`
+	AllowSelf bool     `yaml:"allow_self"`
+}
+
+// ValidationError describes a problem at a line of a policy file
+type ValidationError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ValidationError) Error() string {
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	case e.File != "":
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+	}
+	return e.Msg
+}
+
+// ValidationErrors is returned when a policy is invalid, listing every problem found
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return "invalid policy:\n" + strings.Join(msgs, "\n")
+}
+
+var methods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true,
+	"DELETE": true, "CONNECT": true, "OPTIONS": true, "TRACE": true,
+}
+
+// ParseFile reads and parses the policy file at `path`. Validation errors are reported as
+// `path:line: message`.
+func ParseFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseNamed(path, data)
+}
+
+func parseNamed(path string, data []byte) (*Policy, error) {
+	p, err := Parse(data)
+	var verrs ValidationErrors
+	if errors.As(err, &verrs) {
+		for _, verr := range verrs {
+			verr.File = path
+		}
+	}
+	return p, err
+}
+
+// Parse parses and validates a YAML or JSON policy. If the policy is invalid the error is a
+// ValidationErrors.
+func Parse(data []byte) (*Policy, error) {
+	p := &Policy{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(p); err != nil {
+		if err == io.EOF {
+			return nil, ValidationErrors{{Msg: "policy is empty"}}
+		}
+		return nil, yamlErrors(err)
+	}
+
+	// decode again to find the line of each rule and group
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, yamlErrors(err)
+	}
+	if len(doc.Content) > 0 {
+		if rules := field(doc.Content[0], "rules"); rules != nil {
+			for i := range p.Rules {
+				if i >= len(rules.Content) {
+					break
+				}
+				p.Rules[i].node = rules.Content[i]
+				allow := field(rules.Content[i], "allow")
+				for j := range p.Rules[i].Allow {
+					if allow != nil && j < len(allow.Content) {
+						p.Rules[i].Allow[j].node = allow.Content[j]
+					}
+				}
+			}
+		}
+	}
+
+	if errs := p.validate(&doc); len(errs) > 0 {
+		return nil, errs
+	}
+	p.compile()
+	return p, nil
+}
+
+// Checkers returns the compiled checker groups of the policy, one for each group of each rule
+func (p *Policy) Checkers() [][]certauth.RequestChecker {
+	return p.groups
+}
+
+// Auth creates an Auth configured with `opts` which enforces the policy. The policy's groups
+// are added after any checkers configured by `opts`.
+func (p *Policy) Auth(opts ...certauth.AuthOption) *certauth.Auth {
+	for _, group := range p.groups {
+		opts = append(opts, certauth.WithRequestCheckers(group...))
+	}
+	return certauth.New(opts...)
+}
+
+// Load parses the policy file at `path` and creates an Auth which enforces it. See Watcher to
+// reload the file when it changes.
+func Load(path string, opts ...certauth.AuthOption) (*certauth.Auth, error) {
+	p, err := ParseFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return p.Auth(opts...), nil
+}
+
+func (p *Policy) validate(doc *yaml.Node) ValidationErrors {
+	var errs ValidationErrors
+	errorf := func(node *yaml.Node, format string, args ...interface{}) {
+		ve := &ValidationError{Msg: fmt.Sprintf(format, args...)}
+		if node != nil {
+			ve.Line = node.Line
+		}
+		errs = append(errs, ve)
+	}
+
+	var root *yaml.Node
+	if len(doc.Content) > 0 {
+		root = doc.Content[0]
+	}
+	if p.Version != Version {
+		errorf(valueOf(root, "version"), "unsupported policy version %d, expected %d", p.Version, Version)
+	}
+	if len(p.Rules) == 0 {
+		errorf(root, "policy has no rules")
+	}
+
+	names := map[string]bool{}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.Name == "" {
+			rule.Name = "rule " + strconv.Itoa(i+1)
+		}
+		if names[rule.Name] {
+			errorf(valueOf(rule.node, "name"), "duplicate rule name %q", rule.Name)
+		}
+		names[rule.Name] = true
+
+		for j, m := range rule.Methods {
+			rule.Methods[j] = strings.ToUpper(m)
+			if !methods[rule.Methods[j]] {
+				errorf(valueOf(rule.node, "methods"), "rule %q: unknown method %q", rule.Name, m)
+			}
+		}
+		for _, raw := range rule.Paths {
+			if !strings.HasPrefix(raw, "/") {
+				errorf(valueOf(rule.node, "paths"), "rule %q: path %q must start with /", rule.Name, raw)
+				continue
+			}
+			pattern, err := certauth.ParseRoutePattern(raw)
+			if err != nil {
+				errorf(valueOf(rule.node, "paths"), "rule %q: %s", rule.Name, err)
+				continue
+			}
+			rule.patterns = append(rule.patterns, pattern)
+		}
+		if len(rule.Allow) == 0 {
+			errorf(rule.node, "rule %q allows no clients", rule.Name)
+		}
+
+		for j := range rule.Allow {
+			g := &rule.Allow[j]
+			if g.Any {
+				if len(g.OU) > 0 || len(g.CN) > 0 || g.SAN != nil || g.PantheonSite != nil {
+					errorf(g.node, "rule %q: group %d: any can't be combined with other matchers", rule.Name, j+1)
+				}
+				continue
+			}
+			if len(g.OU) == 0 && len(g.CN) == 0 && g.SAN == nil && g.PantheonSite == nil {
+				errorf(g.node, "rule %q: group %d has no matchers, use `any: true` to allow all clients",
+					rule.Name, j+1)
+			}
+			var err error
+			if g.ouAndCNs.OUs, err = certauth.ParseMatchers(g.OU); err != nil {
+				errorf(valueOf(g.node, "ou"), "rule %q: group %d: %s", rule.Name, j+1, err)
+			}
+			if g.ouAndCNs.CNs, err = certauth.ParseMatchers(g.CN); err != nil {
+				errorf(valueOf(g.node, "cn"), "rule %q: group %d: %s", rule.Name, j+1, err)
+			}
+			if san := g.SAN; san != nil {
+				sanNode := valueOf(g.node, "san")
+				if len(san.DNS) == 0 && len(san.URI) == 0 && len(san.Email) == 0 && len(san.IP) == 0 {
+					errorf(sanNode, "rule %q: group %d: san has no names", rule.Name, j+1)
+				}
+				for _, u := range san.URI {
+					if parsed, err := url.Parse(u); err != nil || parsed.Scheme == "" {
+						errorf(valueOf(sanNode, "uri"), "rule %q: group %d: invalid URI %q", rule.Name, j+1, u)
+					}
+				}
+				for _, ip := range san.IP {
+					ipNet, err := parseIPNet(ip)
+					if err != nil {
+						errorf(valueOf(sanNode, "ip"), "rule %q: group %d: %s", rule.Name, j+1, err)
+						continue
+					}
+					san.ipNets = append(san.ipNets, ipNet)
+				}
+			}
+			if site := g.PantheonSite; site != nil {
+				if len(site.SiteOUs) == 0 {
+					errorf(valueOf(g.node, "pantheon_site"), "rule %q: group %d: pantheon_site has no site_ous",
+						rule.Name, j+1)
+				}
+				// the site checker passes clients outside site_ous, the group must restrict their OUs
+				if len(g.OU) == 0 {
+					errorf(valueOf(g.node, "pantheon_site"),
+						"rule %q: group %d: pantheon_site requires ou, it allows every client without one of its site_ous",
+						rule.Name, j+1)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+func (p *Policy) compile() {
+	p.groups = nil
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		for j := range rule.Allow {
+			p.groups = append(p.groups, []certauth.RequestChecker{
+				scopedGroup{rule: rule, checkers: rule.Allow[j].checkers()},
+			})
+		}
+	}
+}
+
+func (g *Group) checkers() []certauth.RequestChecker {
+	var cks []certauth.RequestChecker
+	if len(g.OU) > 0 || len(g.CN) > 0 {
+		cks = append(cks, certauth.AdaptChecker(g.ouAndCNs))
+	}
+	if g.SAN != nil {
+		cks = append(cks, g.SAN)
+	}
+	if g.PantheonSite != nil {
+		cks = append(cks, certauth.AdaptChecker(pantheon_auth.PantheonSiteAuthChecker{
+			SiteOUs:   g.PantheonSite.SiteOUs,
+			AllowSelf: g.PantheonSite.AllowSelf,
+		}))
+	}
+	return cks
+}
+
+// scopedGroup runs the checkers of one group of a rule, denying requests outside the rule's scope
+type scopedGroup struct {
+	rule     *Rule
+	checkers []certauth.RequestChecker
+}
+
+func (s scopedGroup) Check(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+	if req.Request != nil {
+		u := req.Request.URL
+		ps, ok := s.rule.match(req.Request.Method, u.EscapedPath())
+		if ok {
+			// routers such as httprouter route on the unescaped path, so `/a%2Fb` may be served by
+			// the handler of `/a/b`: the rule only applies if it covers both
+			_, ok = s.rule.match(req.Request.Method, (&url.URL{Path: u.Path}).EscapedPath())
+		}
+		if !ok {
+			return certauth.Deny(fmt.Sprintf(
+				"rule %q does not apply to %s %s", s.rule.Name, req.Request.Method, req.Request.URL.Path,
+			)), nil
+		}
+		if req.Params == nil && ps != nil {
+			scoped := *req
+			scoped.Params = ps
+			req = &scoped
+		}
+	}
+
+	values := make(map[certauth.ContextKey]certauth.ContextValue)
+	for _, ck := range s.checkers {
+		d, err := ck.Check(ctx, req)
+		if err != nil {
+			return certauth.Decision{}, fmt.Errorf("rule %q: %w", s.rule.Name, err)
+		}
+		if !d.Allowed {
+			return certauth.Deny(fmt.Sprintf("rule %q: %s", s.rule.Name, d.Reason)), nil
+		}
+		for k, v := range d.Values {
+			values[k] = v
+		}
+	}
+	return certauth.Allow(values), nil
+}
+
+// match reports whether the rule applies to a request, returning the params captured by the
+// matching path pattern
+func (r *Rule) match(method, path string) (httprouter.Params, bool) {
+	if len(r.Methods) > 0 && !contains(r.Methods, method) &&
+		// as with certauth.RoutePattern, GET also covers HEAD
+		!(method == http.MethodHead && contains(r.Methods, http.MethodGet)) {
+		return nil, false
+	}
+	if len(r.patterns) == 0 {
+		return nil, true
+	}
+	for _, pattern := range r.patterns {
+		if ps, ok := pattern.MatchPath(path); ok {
+			return ps, true
+		}
+	}
+	return nil, false
+}
+
+// Check implements certauth.RequestChecker, allowing clients with a listed name of each type
+func (m *SANMatch) Check(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+	id := req.Identity
+	if len(m.DNS) > 0 && !intersects(m.DNS, id.DNSNames) {
+		return certauth.Deny(fmt.Sprintf(
+			"cert failed DNS SAN validation for %v, allowed: %v", id.DNSNames, m.DNS)), nil
+	}
+	if len(m.URI) > 0 && !intersects(m.URI, uriStrings(id.URIs)) {
+		return certauth.Deny(fmt.Sprintf(
+			"cert failed URI SAN validation for %v, allowed: %v", id.URIs, m.URI)), nil
+	}
+	if len(m.Email) > 0 && !intersects(m.Email, id.EmailAddresses) {
+		return certauth.Deny(fmt.Sprintf(
+			"cert failed email SAN validation for %v, allowed: %v", id.EmailAddresses, m.Email)), nil
+	}
+	if len(m.ipNets) > 0 && !m.allowedIP(id.IPAddresses) {
+		return certauth.Deny(fmt.Sprintf(
+			"cert failed IP SAN validation for %v, allowed: %v", id.IPAddresses, m.IP)), nil
+	}
+	return certauth.Allow(nil), nil
+}
+
+func (m *SANMatch) allowedIP(ips []net.IP) bool {
+	for _, ip := range ips {
+		for _, ipNet := range m.ipNets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//
+// Unexported helper functions below
+//
+
+func parseIPNet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", s)
+		}
+		return ipNet, nil
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address %q", s)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// yamlErrors converts the errors reported by the YAML decoder into ValidationErrors
+func yamlErrors(err error) ValidationErrors {
+	var msgs []string
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		msgs = typeErr.Errors
+	} else {
+		msgs = []string{err.Error()}
+	}
+	errs := make(ValidationErrors, 0, len(msgs))
+	for _, msg := range msgs {
+		ve := &ValidationError{Msg: strings.TrimPrefix(msg, "yaml: ")}
+		if m := yamlLine.FindStringSubmatch(msg); m != nil {
+			ve.Line, _ = strconv.Atoi(m[1])
+			ve.Msg = m[2]
+		}
+		errs = append(errs, ve)
+	}
+	return errs
+}
+
+// field returns the value of `key` in a mapping node, or nil
+func field(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// valueOf is like field, but falls back to the mapping itself so errors always have a line
+func valueOf(node *yaml.Node, key string) *yaml.Node {
+	if v := field(node, key); v != nil {
+		return v
+	}
+	return node
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func uriStrings(uris []*url.URL) []string {
+	strs := make([]string, 0, len(uris))
+	for _, u := range uris {
+		strs = append(strs, u.String())
+	}
+	return strs
+}
+
+func intersects(allowed, actual []string) bool {
+	for _, s := range actual {
+		if contains(allowed, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,325 @@
+package policy_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/pantheon"
+	"github.com/pantheon-systems/go-certauth/policy"
+)
+
+const testPolicy = `
+version: 1
+rules:
+  - name: site-api
+    methods: [GET, post]
+    paths: ["/sites/:site/**"]
+    allow:
+      - ou: [site]
+        pantheon_site: {site_ous: [site], allow_self: true}
+      - ou: [backend]
+        cn: [dashboard.example.com, "glob:*.ops.example.com"]
+  - name: internal
+    paths: ["/internal/{name}"]
+    allow:
+      - san:
+          dns: [worker.internal]
+          ip: [10.0.0.0/8]
+  - name: health
+    paths: [/health]
+    allow:
+      - any: true
+`
+
+const siteID = "3d4b1c8e-8a5c-4f5e-9b7a-2f1e6d3c9a01"
+
+type testClient struct {
+	ou, cn string
+	dns    []string
+	ips    []net.IP
+}
+
+func (c testClient) chains() [][]*x509.Certificate {
+	return [][]*x509.Certificate{{{
+		Subject:     pkix.Name{OrganizationalUnit: []string{c.ou}, CommonName: c.cn},
+		DNSNames:    c.dns,
+		IPAddresses: c.ips,
+	}}}
+}
+
+func serve(auth *certauth.Auth, method, path string, client testClient) (int, string) {
+	var site string
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site, _ = r.Context().Value(pantheon_auth.PantheonSite).(string)
+	}))
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, "https://foo.bar"+path, nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: client.chains()}
+	handler.ServeHTTP(w, req)
+	return w.Code, site
+}
+
+func TestPolicy(t *testing.T) {
+	p, err := policy.Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(p.Checkers()); n != 4 {
+		t.Errorf("expected 4 checker groups, got %d", n)
+	}
+	auth := p.Auth()
+
+	site := testClient{ou: "site", cn: "dev." + siteID + ".example.com"}
+	backend := testClient{ou: "backend", cn: "dashboard.example.com"}
+	ops := testClient{ou: "backend", cn: "bastion.ops.example.com"}
+	worker := testClient{ou: "worker", cn: "w1", dns: []string{"worker.internal"}, ips: []net.IP{net.ParseIP("10.1.2.3")}}
+	outsider := testClient{ou: "worker", cn: "w2", dns: []string{"worker.internal"}, ips: []net.IP{net.ParseIP("192.168.0.1")}}
+
+	tests := []struct {
+		Name    string
+		Method  string
+		Path    string
+		Client  testClient
+		ExpCode int
+		ExpSite string
+	}{
+		{"SiteOwn", "GET", "/sites/" + siteID + "/env", site, http.StatusOK, siteID},
+		{"SiteSelf", "POST", "/sites/self/env", site, http.StatusOK, siteID},
+		{"SiteOther", "GET", "/sites/other/env", site, http.StatusForbidden, ""},
+		{"SiteHead", "HEAD", "/sites/" + siteID + "/env", site, http.StatusOK, siteID},
+		{"SiteWrongMethod", "DELETE", "/sites/" + siteID + "/env", site, http.StatusForbidden, ""},
+		{"Backend", "GET", "/sites/other/env", backend, http.StatusOK, ""},
+		{"BackendGlob", "GET", "/sites/other/env", ops, http.StatusOK, ""},
+		{"BackendInternal", "GET", "/internal/jobs", backend, http.StatusForbidden, ""},
+		{"Worker", "PUT", "/internal/jobs", worker, http.StatusOK, ""},
+		{"WorkerWrongIP", "PUT", "/internal/jobs", outsider, http.StatusForbidden, ""},
+		{"WorkerNested", "PUT", "/internal/jobs/1", worker, http.StatusForbidden, ""},
+		// httprouter would serve it as /internal/jobs/1
+		{"WorkerEscapedNested", "PUT", "/internal/jobs%2F1", worker, http.StatusForbidden, ""},
+		{"Health", "GET", "/health", outsider, http.StatusOK, ""},
+		{"Unscoped", "GET", "/other", backend, http.StatusForbidden, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			code, site := serve(auth, tc.Method, tc.Path, tc.Client)
+			if code != tc.ExpCode {
+				t2.Errorf("expected %d, got %d", tc.ExpCode, code)
+			}
+			if site != tc.ExpSite {
+				t2.Errorf("expected site %q, got %q", tc.ExpSite, site)
+			}
+		})
+	}
+}
+
+func TestPolicyJSON(t *testing.T) {
+	p, err := policy.Parse([]byte(`{
+  "version": 1,
+  "rules": [{"paths": ["/health"], "allow": [{"cn": ["foo.com"]}]}]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Rules[0].Name != "rule 1" {
+		t.Errorf("expected a default rule name, got %q", p.Rules[0].Name)
+	}
+	if code, _ := serve(p.Auth(), "GET", "/health", testClient{cn: "foo.com"}); code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+}
+
+func TestPolicyValidation(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Policy string
+		Errs   []string
+	}{
+		{"Empty", ``, []string{"policy is empty"}},
+		{"Syntax", "version: 1\nrules: [", []string{"line 2: did not find expected node content"}},
+		{"UnknownField", "version: 1\nrules:\n  - allow:\n      - oo: [site]\n", []string{
+			"line 4: field oo not found in type policy.Group",
+		}},
+		{"Version", "version: 2\nrules:\n  - allow: [{any: true}]\n", []string{
+			"line 1: unsupported policy version 2, expected 1",
+		}},
+		{"NoRules", "version: 1\n", []string{"line 1: policy has no rules"}},
+		{"Rules", `version: 1
+rules:
+  - name: a
+    methods: [FETCH]
+    paths: [foo, "/a/**/b"]
+    allow:
+      - {}
+      - any: true
+        ou: [site]
+  - name: a
+  - name: b
+    allow:
+      - san: {uri: [not-a-uri], ip: [10.0.0.300]}
+      - pantheon_site: {allow_self: true}
+        cn: ["regex:("]
+`, []string{
+			`line 4: rule "a": unknown method "FETCH"`,
+			`line 5: rule "a": path "foo" must start with /`,
+			`line 5: rule "a": route "/a/**/b" may only match the rest of the path in its final segment`,
+			`line 7: rule "a": group 1 has no matchers, use ` + "`any: true`" + ` to allow all clients`,
+			`line 8: rule "a": group 2: any can't be combined with other matchers`,
+			`line 10: duplicate rule name "a"`,
+			`line 10: rule "a" allows no clients`,
+			`line 13: rule "b": group 1: invalid URI "not-a-uri"`,
+			`line 13: rule "b": group 1: invalid IP address "10.0.0.300"`,
+			`line 15: rule "b": group 2: invalid pattern "regex:(": error parsing regexp: missing closing ): ` + "`(`",
+			`line 14: rule "b": group 2: pantheon_site has no site_ous`,
+			`line 14: rule "b": group 2: pantheon_site requires ou, it allows every client without one of its site_ous`,
+		}},
+		{"PantheonSiteOnly", "version: 1\nrules:\n  - allow:\n      - pantheon_site: {site_ous: [site]}\n", []string{
+			`line 4: rule "rule 1": group 1: pantheon_site requires ou, it allows every client without one of its site_ous`,
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			_, err := policy.Parse([]byte(tc.Policy))
+			var errs policy.ValidationErrors
+			if !errors.As(err, &errs) {
+				t2.Fatalf("expected ValidationErrors, got %v", err)
+			}
+			var msgs []string
+			for _, e := range errs {
+				msgs = append(msgs, e.Error())
+			}
+			if strings.Join(msgs, "\n") != strings.Join(tc.Errs, "\n") {
+				t2.Errorf("expected:\n%s\ngot:\n%s", strings.Join(tc.Errs, "\n"), strings.Join(msgs, "\n"))
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	write(t, path, "version: 1\nrules:\n  - allow:\n      - {}\n")
+	_, err := policy.Load(path)
+	if err == nil || !strings.Contains(err.Error(), path+":4: rule \"rule 1\": group 1 has no matchers") {
+		t.Errorf("expected a line numbered error for %s, got: %v", path, err)
+	}
+
+	write(t, path, testPolicy)
+	auth, err := policy.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, _ := serve(auth, "GET", "/health", testClient{}); code != http.StatusOK {
+		t.Errorf("expected 200, got %d", code)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	write(t, path, "version: 1\nrules:\n  - allow: [{cn: [foo.com]}]\n")
+
+	auth := certauth.New()
+	var reloads int
+	w, err := policy.NewWatcher(policy.WatcherConfig{
+		Path:     path,
+		Auth:     auth,
+		OnReload: func(*policy.Policy) { reloads++ },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo, bar := testClient{cn: "foo.com"}, testClient{cn: "bar.com"}
+	expectCodes := func(fooCode, barCode int) {
+		t.Helper()
+		if code, _ := serve(auth, "GET", "/", foo); code != fooCode {
+			t.Errorf("expected %d for foo.com, got %d", fooCode, code)
+		}
+		if code, _ := serve(auth, "GET", "/", bar); code != barCode {
+			t.Errorf("expected %d for bar.com, got %d", barCode, code)
+		}
+	}
+	expectCodes(http.StatusOK, http.StatusForbidden)
+
+	// nothing changed
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if reloads != 0 {
+		t.Errorf("expected no reloads, got %d", reloads)
+	}
+
+	write(t, path, "version: 1\nrules:\n  - allow: [{cn: [bar.com]}]\n")
+	if err := w.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	expectCodes(http.StatusForbidden, http.StatusOK)
+	if reloads != 1 || w.Policy().Rules[0].Allow[0].CN[0] != "bar.com" {
+		t.Errorf("expected the new policy to be applied, got %d reloads", reloads)
+	}
+
+	// an invalid policy is reported and the previous one is kept
+	write(t, path, "version: 1\nrules:\n  - allow: [{}]\n")
+	if err := w.Reload(); err == nil {
+		t.Error("expected an error for an invalid policy")
+	}
+	expectCodes(http.StatusForbidden, http.StatusOK)
+
+	// the poller picks up changes and reports errors
+	errs := make(chan error, 1)
+	w, err = policy.NewWatcher(policy.WatcherConfig{
+		Path:     path + ".2",
+		Auth:     auth,
+		Interval: 10 * time.Millisecond,
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+	write(t, path+".2", testPolicy)
+	if w, err = policy.NewWatcher(policy.WatcherConfig{
+		Path:     path + ".2",
+		Auth:     auth,
+		Interval: 10 * time.Millisecond,
+		OnError: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	w.Start()
+	defer w.Stop()
+	write(t, path+".2", "not: [a policy")
+	select {
+	case <-errs:
+	case <-time.After(5 * time.Second):
+		t.Error("expected the poller to report an error")
+	}
+}
+
+func write(t *testing.T, name, data string) {
+	t.Helper()
+	// write then rename so the watcher never sees a partial file
+	if err := os.WriteFile(name+".tmp", []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,101 @@
+package policy
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/internal/filewatch"
+)
+
+// WatcherConfig is the configuration used to create a Watcher
+type WatcherConfig struct {
+	// Path is the policy file to watch
+	Path string
+
+	// Auth is updated with the policy's checkers when the file changes. Any checkers it was
+	// configured with are replaced, so it should be configured solely by the policy.
+	Auth *certauth.Auth
+
+	// Interval is how often the file is checked for changes. Defaults to 30 seconds.
+	Interval time.Duration
+
+	// OnError is called when a changed file fails to load or validate. The previously loaded
+	// policy stays in use.
+	OnError func(error)
+
+	// OnReload is called after a new policy has been applied
+	OnReload func(*Policy)
+}
+
+// Watcher watches a policy file and applies it to an Auth whenever it changes, without a
+// restart. A policy which fails to parse or validate is never applied; the previous policy is
+// kept and the error is reported to OnError.
+//
+// The file is polled and compared by its contents, so replacing a mounted config map is noticed.
+type Watcher struct {
+	cfg    WatcherConfig
+	poller *filewatch.Poller
+
+	mu     sync.Mutex
+	policy *Policy
+}
+
+// NewWatcher creates a Watcher, loading the policy file and applying it to the Auth. It returns
+// an error if the file is not a valid policy. Call Start to begin watching for changes.
+func NewWatcher(cfg WatcherConfig) (*Watcher, error) {
+	if cfg.Path == "" || cfg.Auth == nil {
+		return nil, errors.New("watcher requires a policy file and an Auth")
+	}
+	if cfg.Interval == 0 {
+		cfg.Interval = 30 * time.Second
+	}
+
+	w := &Watcher{cfg: cfg}
+	w.poller = filewatch.New([]string{cfg.Path}, cfg.Interval, w.apply)
+	if _, err := w.poller.Poll(true); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Start begins polling the file for changes in the background
+func (w *Watcher) Start() {
+	w.poller.Start(w.Reload, w.cfg.OnError)
+}
+
+// Stop stops polling the file. It is safe to call more than once.
+func (w *Watcher) Stop() {
+	w.poller.Stop()
+}
+
+// Policy returns the policy currently applied to the Auth
+func (w *Watcher) Policy() *Policy {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.policy
+}
+
+// Reload checks the file for changes and applies the new policy. It is called periodically
+// after Start, but may also be called directly, e.g. on SIGHUP.
+func (w *Watcher) Reload() error {
+	changed, err := w.poller.Poll(false)
+	if err == nil && changed && w.cfg.OnReload != nil {
+		w.cfg.OnReload(w.Policy())
+	}
+	return err
+}
+
+// apply parses the changed policy file and applies it to the Auth
+func (w *Watcher) apply(files map[string][]byte) error {
+	p, err := parseNamed(w.cfg.Path, files[w.cfg.Path])
+	if err != nil {
+		return err
+	}
+	w.cfg.Auth.SetCheckers(p.Checkers()...)
+	w.mu.Lock()
+	w.policy = p
+	w.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,328 @@
+// Package promtext implements certauth.Metrics, exposing the measurements in the Prometheus text
+// exposition format without depending on the Prometheus client library.
+//
+//	metrics := promtext.New(promtext.Options{})
+//	auth := certauth.New(
+//		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+//		certauth.WithMetrics(metrics),
+//	)
+//	metrics.WatchCache(auth)
+//	http.Handle("/metrics", metrics)
+//
+// The following metrics are exposed, prefixed by the namespace:
+//   - requests_total{result,reason}: counter of requests allowed or denied, by denial reason
+//   - checker_duration_seconds{route,group,checker}: histogram of the latency of each checker
+//   - client_cert_expiry_days{cn,serial}: gauge of the days until the client certificates seen
+//     expire, negative once they have expired
+//   - decision_cache_{hits,misses,evictions}_total and decision_cache_size, when watching an Auth
+//     with a decision cache
+package promtext
+
+import (
+	"bufio"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// DefaultBuckets are the default upper bounds, in seconds, of the checker latency histogram
+var DefaultBuckets = []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1}
+
+// Options is the configuration of Metrics
+type Options struct {
+	// Namespace prefixes the metric names. Defaults to "certauth".
+	Namespace string
+
+	// Buckets are the upper bounds, in seconds, of the checker latency histogram. Defaults to
+	// DefaultBuckets.
+	Buckets []float64
+
+	// MaxCertificates is the number of client certificates whose expiry is tracked, the least
+	// recently seen are dropped first. Defaults to 1000.
+	MaxCertificates int
+
+	// Now returns the current time, defaults to time.Now. Useful for tests.
+	Now func() time.Time
+}
+
+// Metrics collects the measurements of one or more certauth.Auth and writes them in the
+// Prometheus text format. It is an http.Handler serving them.
+type Metrics struct {
+	opts Options
+
+	mu       sync.Mutex
+	requests map[requestLabels]uint64
+	checkers map[checkerLabels]*histogram
+	certs    map[certLabels]*certExpiry
+	caches   []func() certauth.CacheStats
+}
+
+type requestLabels struct {
+	allowed bool
+	reason  string
+}
+
+type checkerLabels struct {
+	route          string
+	group, checker int
+}
+
+type histogram struct {
+	// counts holds the number of observations in each bucket, they are summed when written
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type certLabels struct {
+	cn, serial string
+}
+
+type certExpiry struct {
+	notAfter time.Time
+	lastSeen time.Time
+}
+
+// New returns Metrics configured with `opts`
+func New(opts Options) *Metrics {
+	if opts.Namespace == "" {
+		opts.Namespace = "certauth"
+	}
+	if opts.Buckets == nil {
+		opts.Buckets = DefaultBuckets
+	}
+	opts.Buckets = append([]float64(nil), opts.Buckets...)
+	sort.Float64s(opts.Buckets)
+	if opts.MaxCertificates <= 0 {
+		opts.MaxCertificates = 1000
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Metrics{
+		opts:     opts,
+		requests: make(map[requestLabels]uint64),
+		checkers: make(map[checkerLabels]*histogram),
+		certs:    make(map[certLabels]*certExpiry),
+	}
+}
+
+// ObserveRequest implements certauth.Metrics
+func (m *Metrics) ObserveRequest(allowed bool, reason string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[requestLabels{allowed, reason}]++
+}
+
+// ObserveChecker implements certauth.Metrics
+func (m *Metrics) ObserveChecker(route string, group, checker int, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := checkerLabels{route, group, checker}
+	h, ok := m.checkers[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.opts.Buckets))}
+		m.checkers[key] = h
+	}
+	secs := latency.Seconds()
+	if i := sort.SearchFloat64s(m.opts.Buckets, secs); i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += secs
+	h.count++
+}
+
+// ObserveCertificate implements certauth.Metrics
+func (m *Metrics) ObserveCertificate(cert *x509.Certificate) {
+	serial := ""
+	if cert.SerialNumber != nil {
+		serial = cert.SerialNumber.String()
+	}
+	key := certLabels{cert.Subject.CommonName, serial}
+	now := m.opts.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok := m.certs[key]; ok {
+		c.lastSeen = now
+		return
+	}
+	if len(m.certs) >= m.opts.MaxCertificates {
+		var oldest certLabels
+		var oldestSeen time.Time
+		for k, c := range m.certs {
+			if oldestSeen.IsZero() || c.lastSeen.Before(oldestSeen) {
+				oldest, oldestSeen = k, c.lastSeen
+			}
+		}
+		delete(m.certs, oldest)
+	}
+	m.certs[key] = &certExpiry{notAfter: cert.NotAfter, lastSeen: now}
+}
+
+// WatchCache exposes the statistics of the decision cache of `a`. Statistics of every watched
+// Auth are summed.
+func (m *Metrics) WatchCache(a *certauth.Auth) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.caches = append(m.caches, a.CacheStats)
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// WriteTo writes the metrics to `w` in the Prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	m.write(bw)
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (m *Metrics) write(w *bufio.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name := m.opts.Namespace + "_requests_total"
+	header(w, name, "counter", "Requests processed, by result and denial reason.")
+	reqs := make([]requestLabels, 0, len(m.requests))
+	for k := range m.requests {
+		reqs = append(reqs, k)
+	}
+	sort.Slice(reqs, func(i, j int) bool {
+		if reqs[i].allowed != reqs[j].allowed {
+			return reqs[i].allowed
+		}
+		return reqs[i].reason < reqs[j].reason
+	})
+	for _, k := range reqs {
+		if k.allowed {
+			sample(w, name, labels("result", "allowed"), float64(m.requests[k]))
+		} else {
+			sample(w, name, labels("result", "denied", "reason", k.reason), float64(m.requests[k]))
+		}
+	}
+
+	name = m.opts.Namespace + "_checker_duration_seconds"
+	header(w, name, "histogram", "Latency of the checkers, by route, group and checker index.")
+	cks := make([]checkerLabels, 0, len(m.checkers))
+	for k := range m.checkers {
+		cks = append(cks, k)
+	}
+	sort.Slice(cks, func(i, j int) bool {
+		a, b := cks[i], cks[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.group != b.group {
+			return a.group < b.group
+		}
+		return a.checker < b.checker
+	})
+	for _, k := range cks {
+		h := m.checkers[k]
+		base := []string{"route", k.route, "group", strconv.Itoa(k.group), "checker", strconv.Itoa(k.checker)}
+		var cumulative uint64
+		for i, le := range m.opts.Buckets {
+			cumulative += h.counts[i]
+			sample(w, name+"_bucket", labels(append(base, "le", formatFloat(le))...), float64(cumulative))
+		}
+		sample(w, name+"_bucket", labels(append(base, "le", "+Inf")...), float64(h.count))
+		sample(w, name+"_sum", labels(base...), h.sum)
+		sample(w, name+"_count", labels(base...), float64(h.count))
+	}
+
+	name = m.opts.Namespace + "_client_cert_expiry_days"
+	header(w, name, "gauge", "Days until the client certificates seen expire.")
+	certs := make([]certLabels, 0, len(m.certs))
+	for k := range m.certs {
+		certs = append(certs, k)
+	}
+	sort.Slice(certs, func(i, j int) bool {
+		if certs[i].cn != certs[j].cn {
+			return certs[i].cn < certs[j].cn
+		}
+		return certs[i].serial < certs[j].serial
+	})
+	now := m.opts.Now()
+	for _, k := range certs {
+		days := m.certs[k].notAfter.Sub(now).Hours() / 24
+		sample(w, name, labels("cn", k.cn, "serial", k.serial), days)
+	}
+
+	if len(m.caches) == 0 {
+		return
+	}
+	var stats certauth.CacheStats
+	for _, cacheStats := range m.caches {
+		s := cacheStats()
+		stats.Hits += s.Hits
+		stats.Misses += s.Misses
+		stats.Evictions += s.Evictions
+		stats.Size += s.Size
+	}
+	for _, c := range []struct {
+		name, typ, help string
+		value           float64
+	}{
+		{"hits_total", "counter", "Decisions reused from the decision cache.", float64(stats.Hits)},
+		{"misses_total", "counter", "Decisions not found in the decision cache.", float64(stats.Misses)},
+		{"evictions_total", "counter", "Decisions evicted from the decision cache.", float64(stats.Evictions)},
+		{"size", "gauge", "Decisions currently in the decision cache.", float64(stats.Size)},
+	} {
+		name = m.opts.Namespace + "_decision_cache_" + c.name
+		header(w, name, c.typ, c.help)
+		sample(w, name, "", c.value)
+	}
+}
+
+func header(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func sample(w *bufio.Writer, name, labels string, value float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, labels, formatFloat(value))
+}
+
+// labels formats name, value pairs as a label set
+func labels(pairs ...string) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i] + `="` + labelEscaper.Replace(pairs[i+1]) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
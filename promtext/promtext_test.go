@@ -0,0 +1,112 @@
+package promtext_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/promtext"
+)
+
+func clientChain(ou, cn string, serial int64, notAfter time.Time) [][]*x509.Certificate {
+	return [][]*x509.Certificate{{{
+		Raw:          []byte(cn),
+		SerialNumber: big.NewInt(serial),
+		NotAfter:     notAfter,
+		Subject:      pkix.Name{OrganizationalUnit: []string{ou}, CommonName: cn},
+	}}}
+}
+
+func TestMetrics(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	metrics := promtext.New(promtext.Options{
+		Buckets:         []float64{1, 10},
+		MaxCertificates: 2,
+		Now:             func() time.Time { return now },
+	})
+	auth := certauth.New(
+		certauth.WithRouteCheckers("/admin/",
+			certauth.AllowOUsandCNs([]string{"endpoint"}, nil),
+			certauth.AllowOUsandCNs(nil, []string{"admin"}),
+		),
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		certauth.WithDecisionCache(certauth.CacheConfig{}),
+		certauth.WithMetrics(metrics),
+	)
+	metrics.WatchCache(auth)
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(path string, chains [][]*x509.Certificate) {
+		req := httptest.NewRequest("GET", "https://foo.bar"+path, nil)
+		if chains != nil {
+			req.TLS = &tls.ConnectionState{VerifiedChains: chains}
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		now = now.Add(time.Second)
+	}
+	serve("/", nil)
+	serve("/", clientChain("site", "site.com", 1, start.Add(48*time.Hour)))
+	serve("/admin/", clientChain("endpoint", "other.com", 2, start.Add(-12*time.Hour)))
+	serve("/admin/", clientChain("endpoint", "admin", 3, start.Add(24*time.Hour)))
+	serve("/admin/", clientChain("endpoint", "admin", 3, start.Add(24*time.Hour)))
+	now = start
+
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body := w.Body.String()
+
+	for _, exp := range []string{
+		"# TYPE certauth_requests_total counter\n",
+		`certauth_requests_total{result="allowed"} 2` + "\n",
+		`certauth_requests_total{result="denied",reason="cn_mismatch"} 1` + "\n",
+		`certauth_requests_total{result="denied",reason="no_cert"} 1` + "\n",
+		`certauth_requests_total{result="denied",reason="ou_mismatch"} 1` + "\n",
+		"# TYPE certauth_checker_duration_seconds histogram\n",
+		`certauth_checker_duration_seconds_bucket{route="",group="0",checker="0",le="1"} 1` + "\n",
+		`certauth_checker_duration_seconds_bucket{route="/admin/",group="0",checker="1",le="+Inf"} 2` + "\n",
+		`certauth_checker_duration_seconds_count{route="/admin/",group="0",checker="0"} 2` + "\n",
+		"# TYPE certauth_client_cert_expiry_days gauge\n",
+		`certauth_client_cert_expiry_days{cn="admin",serial="3"} 1` + "\n",
+		`certauth_client_cert_expiry_days{cn="other.com",serial="2"} -0.5` + "\n",
+		"certauth_decision_cache_hits_total 1\n",
+		"certauth_decision_cache_misses_total 3\n",
+		"certauth_decision_cache_size 1\n",
+	} {
+		if !strings.Contains(body, exp) {
+			t.Errorf("expected the metrics to contain %q, got:\n%s", exp, body)
+		}
+	}
+	// only the 2 most recently seen certificates are tracked
+	if strings.Contains(body, `cn="site.com"`) {
+		t.Errorf("expected the least recently seen certificate to be dropped, got:\n%s", body)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	metrics := promtext.New(promtext.Options{Namespace: "svc"})
+	metrics.ObserveChecker("/a\"b\\c\n", 0, 0, time.Millisecond)
+
+	var b strings.Builder
+	n, err := metrics.WriteTo(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int(n) != b.Len() {
+		t.Errorf("expected %d bytes written, got %d", b.Len(), n)
+	}
+	exp := `svc_checker_duration_seconds_sum{route="/a\"b\\c\n",group="0",checker="0"} 0.001`
+	if !strings.Contains(b.String(), exp) {
+		t.Errorf("expected the metrics to contain %q, got:\n%s", exp, b.String())
+	}
+}
@@ -0,0 +1,351 @@
+// Package revocation provides certificate revocation checks for client certificates which can be
+// used both during the TLS handshake, as a tls.Config.VerifyPeerCertificate hook, and as a
+// certauth.RequestChecker.
+package revocation
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// ErrRevoked is matched (using errors.Is) by the errors returned for revoked certificates
+var ErrRevoked = errors.New("certificate revoked")
+
+// RevokedError is returned when a certificate in the client's chain has been revoked
+type RevokedError struct {
+	Serial    string
+	Issuer    string
+	RevokedAt time.Time
+}
+
+func (e *RevokedError) Error() string {
+	return fmt.Sprintf(
+		"certificate %s issued by %q was revoked at %s",
+		e.Serial, e.Issuer, e.RevokedAt.UTC().Format(time.RFC3339),
+	)
+}
+
+// Is reports whether target is ErrRevoked
+func (e *RevokedError) Is(target error) bool {
+	return target == ErrRevoked
+}
+
+// CRLConfig is the configuration used to create a CRLChecker
+type CRLConfig struct {
+	// Sources are the locations of the CRLs to load, either file paths or http(s) URLs.
+	// CRLs may be DER or PEM encoded. Certificates are checked against every CRL of their
+	// issuer, and are rejected while any of them is stale.
+	Sources []string
+
+	// Issuers, if set, are the CA certificates that CRLs must be signed by. CRLs are rejected at
+	// load time if their signature can't be verified against one of these.
+	// CRLs are always verified against the issuer in the client's verified chain before use.
+	Issuers []*x509.Certificate
+
+	// RefreshInterval is used to refresh CRLs which do not specify a NextUpdate.
+	// Defaults to 1 hour.
+	RefreshInterval time.Duration
+
+	// RetryInterval is how long to wait before fetching a CRL again after failing to refresh
+	// it. Meanwhile certificates from its issuer are rejected as its CRL is stale. Defaults to 1
+	// minute.
+	RetryInterval time.Duration
+
+	// HTTPClient is used to fetch CRLs from URLs. Defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+
+	// RequireCRL rejects certificates whose issuer has no loaded CRL. By default such
+	// certificates are allowed.
+	RequireCRL bool
+
+	// Now returns the current time, defaults to time.Now. Useful for tests.
+	Now func() time.Time
+}
+
+// CRLChecker checks certificates against a set of cached CRLs which are refreshed when they reach
+// their NextUpdate time.
+type CRLChecker struct {
+	cfg CRLConfig
+
+	mu      sync.Mutex
+	crls    map[string]*cachedCRL // by source
+	sources map[string]*crlSource // by source
+}
+
+type cachedCRL struct {
+	list    *x509.RevocationList
+	revoked map[string]time.Time // revocation time by serial
+	expires time.Time
+	// result of the signature check against each issuer, by the issuer's raw certificate
+	signatures map[string]error
+}
+
+// crlSource tracks the refreshes of a source
+type crlSource struct {
+	// refreshing is closed when the refresh in progress completes, nil if there is none
+	refreshing chan struct{}
+	// retryAt is when the source may be fetched again after a failed refresh
+	retryAt time.Time
+}
+
+// NewCRLChecker creates a CRLChecker and loads all of the configured CRLs, returning an error if
+// any of them can't be loaded.
+func NewCRLChecker(cfg CRLConfig) (*CRLChecker, error) {
+	if len(cfg.Sources) == 0 {
+		return nil, errors.New("no CRL sources configured")
+	}
+	if cfg.RefreshInterval == 0 {
+		cfg.RefreshInterval = time.Hour
+	}
+	if cfg.RetryInterval == 0 {
+		cfg.RetryInterval = time.Minute
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	c := &CRLChecker{cfg: cfg, crls: make(map[string]*cachedCRL), sources: make(map[string]*crlSource)}
+	for _, src := range cfg.Sources {
+		crl, err := c.load(context.Background(), src)
+		if err != nil {
+			return nil, err
+		}
+		c.crls[src] = crl
+		c.sources[src] = &crlSource{}
+	}
+	return c, nil
+}
+
+// Refresh reloads every CRL which has passed its NextUpdate time, even those which recently
+// failed to refresh. CRLs which can't be refreshed are kept until they expire; the first error
+// encountered is returned.
+// Refresh is called automatically when checking certificates, so calling it is optional.
+func (c *CRLChecker) Refresh(ctx context.Context) error {
+	return c.refresh(ctx, true)
+}
+
+// refresh reloads the expired CRLs, unless they failed to refresh within the RetryInterval and
+// `retry` is false. The CRLs are fetched without holding c.mu; concurrent callers wait for the
+// refreshes in progress rather than fetching the same CRLs again.
+func (c *CRLChecker) refresh(ctx context.Context, retry bool) error {
+	var (
+		due     []string
+		pending []chan struct{}
+	)
+	now := c.cfg.Now()
+	c.mu.Lock()
+	for _, src := range c.cfg.Sources {
+		if cur, ok := c.crls[src]; ok && now.Before(cur.expires) {
+			continue
+		}
+		st := c.sources[src]
+		switch {
+		case st.refreshing != nil:
+			pending = append(pending, st.refreshing)
+		case retry || !now.Before(st.retryAt):
+			st.refreshing = make(chan struct{})
+			due = append(due, src)
+		}
+	}
+	c.mu.Unlock()
+
+	var firstErr error
+	for _, src := range due {
+		crl, err := c.load(ctx, src)
+		c.mu.Lock()
+		st := c.sources[src]
+		if err != nil {
+			// a cancelled request says nothing about the CRL server
+			if ctx.Err() == nil {
+				st.retryAt = c.cfg.Now().Add(c.cfg.RetryInterval)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			c.crls[src] = crl
+		}
+		close(st.refreshing)
+		st.refreshing = nil
+		c.mu.Unlock()
+	}
+	for _, done := range pending {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return firstErr
+}
+
+// CheckChain checks every certificate in a verified chain, except the root, against the CRL of
+// its issuer.
+func (c *CRLChecker) CheckChain(ctx context.Context, chain []*x509.Certificate) error {
+	// errors are only fatal if they leave us without a current CRL, which is checked below
+	_ = c.refresh(ctx, false)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.cfg.Now()
+	for i := 0; i+1 < len(chain); i++ {
+		cert, issuer := chain[i], chain[i+1]
+		crls, err := c.crlsFor(issuer, now)
+		if err != nil {
+			return err
+		}
+		if len(crls) == 0 {
+			if c.cfg.RequireCRL {
+				return fmt.Errorf("no CRL available for issuer %q", issuer.Subject)
+			}
+			continue
+		}
+		if cert.SerialNumber == nil {
+			continue
+		}
+		// an issuer may publish several CRLs, e.g. from different sources or partitions
+		for _, crl := range crls {
+			if at, ok := crl.revoked[cert.SerialNumber.String()]; ok {
+				return &RevokedError{
+					Serial:    cert.SerialNumber.Text(16),
+					Issuer:    issuer.Subject.String(),
+					RevokedAt: at,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// crlsFor finds the CRLs issued by `issuer`, verifying their signatures. It returns an error if
+// any of them has expired, as its revocations are unknown, or if the only CRLs for the issuer
+// are not signed by it. c.mu must be held.
+func (c *CRLChecker) crlsFor(issuer *x509.Certificate, now time.Time) ([]*cachedCRL, error) {
+	var (
+		crls []*cachedCRL
+		err  error
+	)
+	for _, src := range c.cfg.Sources {
+		crl, ok := c.crls[src]
+		if !ok || !bytes.Equal(crl.list.RawIssuer, issuer.RawSubject) {
+			continue
+		}
+		// each fetched CRL is only verified once per issuer
+		sigErr, checked := crl.signatures[string(issuer.Raw)]
+		if !checked {
+			sigErr = crl.list.CheckSignatureFrom(issuer)
+			crl.signatures[string(issuer.Raw)] = sigErr
+		}
+		if sigErr != nil {
+			err = fmt.Errorf("CRL %s is not signed by %q: %w", src, issuer.Subject, sigErr)
+			continue
+		}
+		if !now.Before(crl.expires) {
+			return nil, fmt.Errorf("CRL %s for %q is stale", src, issuer.Subject)
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		return nil, err
+	}
+	return crls, nil
+}
+
+// VerifyPeerCertificate can be used as tls.Config.VerifyPeerCertificate to reject revoked
+// certificates during the handshake. It must be used with a ClientAuth mode which verifies the
+// client certificate so that verifiedChains is populated.
+func (c *CRLChecker) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		if err := c.CheckChain(context.Background(), chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check implements certauth.RequestChecker
+func (c *CRLChecker) Check(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+	for _, chain := range req.VerifiedChains {
+		if err := c.CheckChain(ctx, chain); err != nil {
+			return certauth.Decision{}, err
+		}
+	}
+	return certauth.Allow(nil), nil
+}
+
+// load fetches, parses and (optionally) verifies the CRL from `src`
+func (c *CRLChecker) load(ctx context.Context, src string) (*cachedCRL, error) {
+	raw, err := c.fetch(ctx, src)
+	if err != nil {
+		return nil, fmt.Errorf("could not load CRL %s: %w", src, err)
+	}
+	if block, _ := pem.Decode(raw); block != nil {
+		raw = block.Bytes
+	}
+	list, err := x509.ParseRevocationList(raw)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse CRL %s: %w", src, err)
+	}
+
+	if len(c.cfg.Issuers) > 0 {
+		var verified bool
+		for _, issuer := range c.cfg.Issuers {
+			if list.CheckSignatureFrom(issuer) == nil {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return nil, fmt.Errorf("CRL %s is not signed by any of the configured issuers", src)
+		}
+	}
+
+	crl := &cachedCRL{
+		list:       list,
+		revoked:    make(map[string]time.Time, len(list.RevokedCertificateEntries)),
+		expires:    list.NextUpdate,
+		signatures: make(map[string]error),
+	}
+	if crl.expires.IsZero() {
+		crl.expires = c.cfg.Now().Add(c.cfg.RefreshInterval)
+	}
+	for _, entry := range list.RevokedCertificateEntries {
+		crl.revoked[entry.SerialNumber.String()] = entry.RevocationTime
+	}
+	return crl, nil
+}
+
+func (c *CRLChecker) fetch(ctx context.Context, src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(src)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
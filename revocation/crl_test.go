@@ -0,0 +1,427 @@
+package revocation_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/revocation"
+)
+
+// testCA is a throwaway certificate authority used to issue certs and CRLs for the tests
+type testCA struct {
+	cert   *x509.Certificate
+	key    crypto.Signer
+	serial int64
+}
+
+func newTestCA(t *testing.T, cn string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, serial: 1}
+}
+
+// issue returns a client certificate chain signed by the CA
+func (ca *testCA) issue(t *testing.T, cn string) []*x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: cn, OrganizationalUnit: []string{"endpoint"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return []*x509.Certificate{cert, ca.cert}
+}
+
+// crl returns a PEM encoded CRL revoking the given certs
+func (ca *testCA) crl(t *testing.T, nextUpdate time.Time, revoked ...*x509.Certificate) []byte {
+	t.Helper()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: nextUpdate,
+	}
+	for _, cert := range revoked {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: time.Now().Add(-time.Minute).Truncate(time.Second),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func writeFile(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func expectRevoked(t *testing.T, err error, revoked bool) {
+	t.Helper()
+	if errors.Is(err, revocation.ErrRevoked) != revoked {
+		t.Errorf("expected revoked=%v, got error: %v", revoked, err)
+	}
+}
+
+func TestCRLFromFile(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	good := ca.issue(t, "good")
+	bad := ca.issue(t, "bad")
+
+	checker, err := revocation.NewCRLChecker(revocation.CRLConfig{
+		Sources: []string{writeFile(t, ca.crl(t, time.Now().Add(time.Hour), bad[0]))},
+		Issuers: []*x509.Certificate{ca.cert},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checker.CheckChain(context.Background(), good); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	err = checker.CheckChain(context.Background(), bad)
+	expectRevoked(t, err, true)
+	var revokedErr *revocation.RevokedError
+	if errors.As(err, &revokedErr) {
+		if revokedErr.Serial != bad[0].SerialNumber.Text(16) || revokedErr.Issuer != "CN=Test CA" {
+			t.Errorf("unexpected revocation details: %+v", revokedErr)
+		}
+	}
+
+	// as a VerifyPeerCertificate hook
+	expectRevoked(t, checker.VerifyPeerCertificate(nil, [][]*x509.Certificate{good, bad}), true)
+	if err := checker.VerifyPeerCertificate(nil, [][]*x509.Certificate{good}); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestCRLMultipleSources(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	good := ca.issue(t, "good")
+	bad := ca.issue(t, "bad")
+
+	// the issuer publishes two CRLs, and only the second revokes `bad`
+	now := time.Now()
+	clock := now
+	checker, err := revocation.NewCRLChecker(revocation.CRLConfig{
+		Sources: []string{
+			writeFile(t, ca.crl(t, now.Add(2*time.Hour))),
+			writeFile(t, ca.crl(t, now.Add(time.Hour), bad[0])),
+		},
+		Now: func() time.Time { return clock },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRevoked(t, checker.CheckChain(context.Background(), good), false)
+	expectRevoked(t, checker.CheckChain(context.Background(), bad), true)
+
+	// once either CRL is stale, its revocations are unknown
+	clock = now.Add(time.Hour + time.Second)
+	err = checker.CheckChain(context.Background(), good)
+	if err == nil || errors.Is(err, revocation.ErrRevoked) {
+		t.Errorf("expected a stale CRL error, got: %v", err)
+	}
+}
+
+func TestCRLFromURLRefreshes(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	client := ca.issue(t, "client")
+
+	now := time.Now()
+	var current atomic.Value
+	current.Store(ca.crl(t, now.Add(time.Hour)))
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		w.Write(current.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	clock := now
+	checker, err := revocation.NewCRLChecker(revocation.CRLConfig{
+		Sources: []string{srv.URL + "/ca.crl"},
+		Now:     func() time.Time { return clock },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectRevoked(t, checker.CheckChain(context.Background(), client), false)
+
+	// the CA revokes the client, but the cached CRL is still current
+	current.Store(ca.crl(t, now.Add(2*time.Hour), client[0]))
+	expectRevoked(t, checker.CheckChain(context.Background(), client), false)
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("expected 1 fetch, got %d", n)
+	}
+
+	// once the cached CRL reaches its NextUpdate it is refreshed
+	clock = now.Add(time.Hour + time.Second)
+	expectRevoked(t, checker.CheckChain(context.Background(), client), true)
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("expected 2 fetches, got %d", n)
+	}
+
+	// if the CRL can't be refreshed once it goes stale, we fail closed
+	srv.Close()
+	clock = now.Add(3 * time.Hour)
+	err = checker.CheckChain(context.Background(), client)
+	if err == nil || errors.Is(err, revocation.ErrRevoked) {
+		t.Errorf("expected a stale CRL error, got: %v", err)
+	}
+}
+
+func TestCRLRefreshBackoff(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	client := ca.issue(t, "client")
+
+	now := time.Now()
+	crl := ca.crl(t, now.Add(time.Hour))
+	var (
+		fetches int32
+		failing atomic.Bool
+	)
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&fetches, 1)
+		if n == 2 {
+			// hold the first refresh until the concurrent checks are waiting on it
+			<-release
+		}
+		if failing.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(crl)
+	}))
+	defer srv.Close()
+
+	var clock atomic.Pointer[time.Time]
+	clock.Store(&now)
+	checker, err := revocation.NewCRLChecker(revocation.CRLConfig{
+		Sources: []string{srv.URL + "/ca.crl"},
+		Now:     func() time.Time { return *clock.Load() },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// concurrent checks of an expired CRL share a single refresh
+	later := now.Add(time.Hour + time.Second)
+	clock.Store(&later)
+	crl = ca.crl(t, now.Add(2*time.Hour))
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() { errs <- checker.CheckChain(context.Background(), client) }()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	for i := 0; i < 3; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("expected 2 fetches, got %d", n)
+	}
+
+	// once a refresh fails the CRL isn't fetched again until the RetryInterval has passed
+	failing.Store(true)
+	stale := now.Add(3 * time.Hour)
+	clock.Store(&stale)
+	for i := 0; i < 3; i++ {
+		if err := checker.CheckChain(context.Background(), client); err == nil {
+			t.Error("expected a stale CRL error")
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 3 {
+		t.Errorf("expected 3 fetches, got %d", n)
+	}
+	retry := stale.Add(2 * time.Minute)
+	clock.Store(&retry)
+	checker.CheckChain(context.Background(), client)
+	if n := atomic.LoadInt32(&fetches); n != 4 {
+		t.Errorf("expected 4 fetches, got %d", n)
+	}
+
+	// an explicit Refresh doesn't wait for the RetryInterval
+	failing.Store(false)
+	crl = ca.crl(t, retry.Add(time.Hour))
+	if err := checker.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.CheckChain(context.Background(), client); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestCRLRefreshCancelled(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	client := ca.issue(t, "client")
+
+	now := time.Now()
+	var (
+		current atomic.Value
+		slow    atomic.Bool
+	)
+	current.Store(ca.crl(t, now.Add(time.Hour)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slow.Load() {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Write(current.Load().([]byte))
+	}))
+	defer srv.Close()
+
+	clock := now.Add(time.Hour + time.Second)
+	checker, err := revocation.NewCRLChecker(revocation.CRLConfig{
+		Sources: []string{srv.URL + "/ca.crl"},
+		Now:     func() time.Time { return clock },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current.Store(ca.crl(t, now.Add(2*time.Hour)))
+
+	// the refresh fails because the request timed out, not because the server is down
+	slow.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := checker.CheckChain(ctx, client); err == nil {
+		t.Error("expected a stale CRL error")
+	}
+
+	// so the next request tries again rather than waiting for the RetryInterval
+	slow.Store(false)
+	if err := checker.CheckChain(context.Background(), client); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestCRLSignature(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	impostor := newTestCA(t, "Test CA")
+	client := ca.issue(t, "client")
+
+	// rejected at load time when issuers are configured
+	_, err := revocation.NewCRLChecker(revocation.CRLConfig{
+		Sources: []string{writeFile(t, impostor.crl(t, time.Now().Add(time.Hour)))},
+		Issuers: []*x509.Certificate{ca.cert},
+	})
+	if err == nil {
+		t.Error("expected CRL signed by another CA to be rejected")
+	}
+
+	// and never used to vouch for certs from the real issuer
+	checker, err := revocation.NewCRLChecker(revocation.CRLConfig{
+		Sources: []string{writeFile(t, impostor.crl(t, time.Now().Add(time.Hour)))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.CheckChain(context.Background(), client); err == nil {
+		t.Error("expected CRL signature verification to fail")
+	}
+}
+
+func TestCRLRequireCRL(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	other := newTestCA(t, "Other CA")
+	client := other.issue(t, "client")
+	crl := writeFile(t, ca.crl(t, time.Now().Add(time.Hour)))
+
+	lenient, _ := revocation.NewCRLChecker(revocation.CRLConfig{Sources: []string{crl}})
+	if err := lenient.CheckChain(context.Background(), client); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	strict, _ := revocation.NewCRLChecker(revocation.CRLConfig{Sources: []string{crl}, RequireCRL: true})
+	if err := strict.CheckChain(context.Background(), client); err == nil {
+		t.Error("expected an error for an issuer without a CRL")
+	}
+}
+
+func TestCRLChecker(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	good := ca.issue(t, "good")
+	bad := ca.issue(t, "bad")
+
+	checker, err := revocation.NewCRLChecker(revocation.CRLConfig{
+		Sources: []string{writeFile(t, ca.crl(t, time.Now().Add(time.Hour), bad[0]))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := certauth.New(
+		certauth.WithRequestCheckers(
+			checker,
+			certauth.AdaptChecker(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+		),
+	)
+
+	for chain, expCode := range map[*[]*x509.Certificate]int{&good: http.StatusOK, &bad: http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "https://foo.bar/", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{*chain}}
+		auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, req)
+		if w.Code != expCode {
+			t.Errorf("expected %d for %s, got %d", expCode, (*chain)[0].Subject.CommonName, w.Code)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package revocation
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+// StaplePolicy determines how an OCSPChecker uses OCSP responses stapled to the TLS handshake.
+// Stapled responses are only available when checking a server's certificate, since TLS has no
+// way for clients to staple.
+type StaplePolicy int
+
+const (
+	// StapleIgnore always queries the responder, ignoring any stapled response
+	StapleIgnore StaplePolicy = iota
+	// StaplePrefer uses a stapled response if present and valid, otherwise queries the responder
+	StaplePrefer
+	// StapleRequire rejects connections without a valid stapled response
+	StapleRequire
+)
+
+// OCSPConfig is the configuration used to create an OCSPChecker
+type OCSPConfig struct {
+	// ResponderURL overrides the responder listed in each certificate's Authority Information
+	// Access extension.
+	ResponderURL string
+
+	// HardFail rejects certificates whose status can't be determined, e.g. because the
+	// responder is unreachable, returns an error or reports the status as unknown. By default
+	// (soft-fail) such certificates are allowed.
+	// Revoked certificates are always rejected.
+	HardFail bool
+
+	// StaplePolicy determines how stapled responses are used by VerifyConnection
+	StaplePolicy StaplePolicy
+
+	// CacheTTL is how long to cache responses which do not specify a NextUpdate.
+	// Defaults to 5 minutes.
+	CacheTTL time.Duration
+
+	// NegativeCacheTTL is how long a failed responder query is remembered, so an unreachable
+	// responder doesn't slow down every check. Defaults to 30 seconds; a negative value queries
+	// the responder on every check.
+	NegativeCacheTTL time.Duration
+
+	// CacheSize bounds the number of cached responses. When it is reached expired entries are
+	// evicted, then those expiring soonest. Defaults to 10000.
+	CacheSize int
+
+	// ClockSkew is the tolerance applied when checking that a response is current, i.e. that
+	// its ThisUpdate is not in the future and its NextUpdate not in the past. Defaults to 5
+	// minutes.
+	ClockSkew time.Duration
+
+	// HTTPClient is used to query the responder. Defaults to a client with a 5 second timeout.
+	HTTPClient *http.Client
+
+	// ObserveLatency, if set, is called after every responder query with the responder's URL,
+	// how long the query took and the error if it failed.
+	ObserveLatency func(responder string, latency time.Duration, err error)
+
+	// Now returns the current time, defaults to time.Now. Useful for tests.
+	Now func() time.Time
+}
+
+// OCSPStats is a snapshot of an OCSPChecker's activity
+type OCSPStats struct {
+	// Queries is the number of requests sent to responders, and Errors the number of those that
+	// failed
+	Queries int64
+	Errors  int64
+	// CacheHits is the number of checks answered from the cache
+	CacheHits int64
+	// TotalLatency and MaxLatency describe the time spent waiting on responders
+	TotalLatency time.Duration
+	MaxLatency   time.Duration
+}
+
+// OCSPChecker checks certificates with their issuer's OCSP responder, caching responses until
+// their NextUpdate time.
+type OCSPChecker struct {
+	cfg OCSPConfig
+
+	mu    sync.Mutex
+	cache map[string]*cachedOCSP
+	stats OCSPStats
+}
+
+type cachedOCSP struct {
+	resp *ocsp.Response
+	// err is set for a failed query, cached for NegativeCacheTTL
+	err     error
+	expires time.Time
+}
+
+// NewOCSPChecker creates an OCSPChecker
+func NewOCSPChecker(cfg OCSPConfig) *OCSPChecker {
+	if cfg.CacheTTL == 0 {
+		cfg.CacheTTL = 5 * time.Minute
+	}
+	if cfg.NegativeCacheTTL == 0 {
+		cfg.NegativeCacheTTL = 30 * time.Second
+	}
+	if cfg.CacheSize <= 0 {
+		cfg.CacheSize = 10000
+	}
+	if cfg.ClockSkew == 0 {
+		cfg.ClockSkew = 5 * time.Minute
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &OCSPChecker{cfg: cfg, cache: make(map[string]*cachedOCSP)}
+}
+
+// Stats returns a snapshot of the checker's activity
+func (c *OCSPChecker) Stats() OCSPStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// CheckChain checks the status of every certificate in a verified chain, except the root.
+func (c *OCSPChecker) CheckChain(ctx context.Context, chain []*x509.Certificate) error {
+	for i := 0; i+1 < len(chain); i++ {
+		if err := c.checkCert(ctx, chain[i], chain[i+1], nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyPeerCertificate can be used as tls.Config.VerifyPeerCertificate to reject revoked
+// certificates during the handshake. It must be used with a ClientAuth mode which verifies the
+// client certificate so that verifiedChains is populated.
+func (c *OCSPChecker) VerifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		if err := c.CheckChain(context.Background(), chain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyConnection can be used as tls.Config.VerifyConnection. It behaves like
+// VerifyPeerCertificate but also applies the StaplePolicy to the leaf certificate.
+func (c *OCSPChecker) VerifyConnection(cs tls.ConnectionState) error {
+	for _, chain := range cs.VerifiedChains {
+		for i := 0; i+1 < len(chain); i++ {
+			var stapled []byte
+			if i == 0 {
+				stapled = cs.OCSPResponse
+				if c.cfg.StaplePolicy == StapleRequire && len(stapled) == 0 {
+					return errors.New("no stapled OCSP response")
+				}
+			}
+			if err := c.checkCert(context.Background(), chain[i], chain[i+1], stapled); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Check implements certauth.RequestChecker
+func (c *OCSPChecker) Check(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+	for _, chain := range req.VerifiedChains {
+		if err := c.CheckChain(ctx, chain); err != nil {
+			return certauth.Decision{}, err
+		}
+	}
+	return certauth.Allow(nil), nil
+}
+
+func (c *OCSPChecker) checkCert(ctx context.Context, cert, issuer *x509.Certificate, stapled []byte) error {
+	resp, err := c.status(ctx, cert, issuer, stapled)
+	if err != nil {
+		if c.cfg.HardFail || (len(stapled) > 0 && c.cfg.StaplePolicy == StapleRequire) {
+			return err
+		}
+		return nil
+	}
+
+	switch resp.Status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return &RevokedError{
+			Serial:    cert.SerialNumber.Text(16),
+			Issuer:    issuer.Subject.String(),
+			RevokedAt: resp.RevokedAt,
+		}
+	default:
+		if c.cfg.HardFail {
+			return fmt.Errorf("OCSP status of certificate %s is unknown", cert.SerialNumber.Text(16))
+		}
+		return nil
+	}
+}
+
+// status returns the OCSP response for `cert` from the staple, the cache or the responder
+func (c *OCSPChecker) status(
+	ctx context.Context, cert, issuer *x509.Certificate, stapled []byte,
+) (*ocsp.Response, error) {
+	now := c.cfg.Now()
+	if len(stapled) > 0 && c.cfg.StaplePolicy != StapleIgnore {
+		resp, err := ocsp.ParseResponseForCert(stapled, cert, issuer)
+		if err == nil {
+			err = c.checkCurrent(resp, cert, now)
+		}
+		// an invalid or stale staple is only used as a hint when it is not required
+		if err == nil || c.cfg.StaplePolicy == StapleRequire {
+			return resp, err
+		}
+	}
+
+	key := cacheKey(cert, issuer)
+	c.mu.Lock()
+	if cached, ok := c.cache[key]; ok && now.Before(cached.expires) {
+		c.stats.CacheHits++
+		c.mu.Unlock()
+		return cached.resp, cached.err
+	}
+	c.mu.Unlock()
+
+	responder := c.cfg.ResponderURL
+	if responder == "" {
+		if len(cert.OCSPServer) == 0 {
+			return nil, fmt.Errorf("certificate %s has no OCSP responder", cert.SerialNumber.Text(16))
+		}
+		responder = cert.OCSPServer[0]
+	}
+
+	start := time.Now()
+	resp, err := c.query(ctx, responder, cert, issuer)
+	latency := time.Since(start)
+	if err == nil {
+		err = c.checkCurrent(resp, cert, now)
+	}
+
+	c.mu.Lock()
+	c.stats.Queries++
+	c.stats.TotalLatency += latency
+	if latency > c.stats.MaxLatency {
+		c.stats.MaxLatency = latency
+	}
+	if err != nil {
+		c.stats.Errors++
+		// a cancelled request says nothing about the responder
+		if c.cfg.NegativeCacheTTL > 0 && ctx.Err() == nil {
+			c.store(key, &cachedOCSP{err: err, expires: now.Add(c.cfg.NegativeCacheTTL)}, now)
+		}
+	} else {
+		expires := resp.NextUpdate
+		if expires.IsZero() {
+			expires = now.Add(c.cfg.CacheTTL)
+		}
+		c.store(key, &cachedOCSP{resp: resp, expires: expires}, now)
+	}
+	c.mu.Unlock()
+
+	if c.cfg.ObserveLatency != nil {
+		c.cfg.ObserveLatency(responder, latency, err)
+	}
+	return resp, err
+}
+
+// checkCurrent rejects responses which are not yet valid or have expired
+func (c *OCSPChecker) checkCurrent(resp *ocsp.Response, cert *x509.Certificate, now time.Time) error {
+	if resp.ThisUpdate.After(now.Add(c.cfg.ClockSkew)) {
+		return fmt.Errorf(
+			"OCSP response for certificate %s is not valid until %s", cert.SerialNumber.Text(16), resp.ThisUpdate,
+		)
+	}
+	if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(now.Add(-c.cfg.ClockSkew)) {
+		return fmt.Errorf(
+			"OCSP response for certificate %s expired at %s", cert.SerialNumber.Text(16), resp.NextUpdate,
+		)
+	}
+	return nil
+}
+
+// store caches an entry, evicting others if the cache is full. c.mu must be held.
+func (c *OCSPChecker) store(key string, entry *cachedOCSP, now time.Time) {
+	if _, ok := c.cache[key]; !ok && len(c.cache) >= c.cfg.CacheSize {
+		for k, e := range c.cache {
+			if !now.Before(e.expires) {
+				delete(c.cache, k)
+			}
+		}
+		for len(c.cache) >= c.cfg.CacheSize {
+			var soonest string
+			for k, e := range c.cache {
+				if soonest == "" || e.expires.Before(c.cache[soonest].expires) {
+					soonest = k
+				}
+			}
+			delete(c.cache, soonest)
+		}
+	}
+	c.cache[key] = entry
+}
+
+func (c *OCSPChecker) query(
+	ctx context.Context, responder string, cert, issuer *x509.Certificate,
+) (*ocsp.Response, error) {
+	body, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responder, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	httpResp, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("OCSP request to %s failed: %w", responder, err)
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP request to %s failed: unexpected status %s", responder, httpResp.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	return ocsp.ParseResponseForCert(raw, cert, issuer)
+}
+
+func cacheKey(cert, issuer *x509.Certificate) string {
+	return string(issuer.RawSubjectPublicKeyInfo) + "/" + cert.SerialNumber.String()
+}
@@ -0,0 +1,387 @@
+package revocation_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/pantheon-systems/go-certauth"
+	"github.com/pantheon-systems/go-certauth/revocation"
+)
+
+// fakeResponder is an in-process OCSP responder for a testCA
+type fakeResponder struct {
+	ca *testCA
+	// thisUpdate and nextUpdate are the offsets from now of the responses' validity, defaulting
+	// to a minute ago and no NextUpdate
+	thisUpdate time.Duration
+	nextUpdate time.Duration
+
+	mu      sync.Mutex
+	revoked map[string]bool
+	unknown map[string]bool
+	queries int
+}
+
+func newFakeResponder(ca *testCA) *fakeResponder {
+	return &fakeResponder{ca: ca, revoked: map[string]bool{}, unknown: map[string]bool{}}
+}
+
+func (f *fakeResponder) revoke(cert *x509.Certificate) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.revoked[cert.SerialNumber.String()] = true
+}
+
+func (f *fakeResponder) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.queries
+}
+
+func (f *fakeResponder) respond(t *testing.T, serial *big.Int) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tmpl := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: serial,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+	}
+	if f.thisUpdate != 0 {
+		tmpl.ThisUpdate = time.Now().Add(f.thisUpdate)
+	}
+	if f.nextUpdate != 0 {
+		tmpl.NextUpdate = time.Now().Add(f.nextUpdate)
+	}
+	if f.revoked[serial.String()] {
+		tmpl.Status = ocsp.Revoked
+		tmpl.RevokedAt = time.Now().Add(-time.Minute).Truncate(time.Second)
+	} else if f.unknown[serial.String()] {
+		tmpl.Status = ocsp.Unknown
+	}
+	resp, err := ocsp.CreateResponse(f.ca.cert, f.ca.cert, tmpl, f.ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func (f *fakeResponder) server(t *testing.T) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.queries++
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(f.respond(t, req.SerialNumber))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOCSPChecker(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	good := ca.issue(t, "good")
+	bad := ca.issue(t, "bad")
+	responder := newFakeResponder(ca)
+	responder.nextUpdate = time.Hour
+	responder.revoke(bad[0])
+	srv := responder.server(t)
+
+	var observed []string
+	checker := revocation.NewOCSPChecker(revocation.OCSPConfig{
+		ResponderURL: srv.URL,
+		ObserveLatency: func(url string, latency time.Duration, err error) {
+			observed = append(observed, url)
+		},
+	})
+
+	if err := checker.CheckChain(context.Background(), good); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	expectRevoked(t, checker.CheckChain(context.Background(), bad), true)
+	expectRevoked(t, checker.VerifyPeerCertificate(nil, [][]*x509.Certificate{bad}), true)
+
+	// the second check of `bad` came from the cache
+	if n := responder.count(); n != 2 {
+		t.Errorf("expected 2 responder queries, got %d", n)
+	}
+	stats := checker.Stats()
+	if stats.Queries != 2 || stats.CacheHits != 1 || stats.Errors != 0 || stats.TotalLatency <= 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if len(observed) != 2 || observed[0] != srv.URL {
+		t.Errorf("unexpected latency observations: %v", observed)
+	}
+
+	// as a certauth checker
+	auth := certauth.New(certauth.WithRequestCheckers(checker))
+	for chain, expCode := range map[*[]*x509.Certificate]int{&good: http.StatusOK, &bad: http.StatusForbidden} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "https://foo.bar/", nil)
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{*chain}}
+		auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, req)
+		if w.Code != expCode {
+			t.Errorf("expected %d for %s, got %d", expCode, (*chain)[0].Subject.CommonName, w.Code)
+		}
+	}
+}
+
+func TestOCSPCacheExpiry(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	client := ca.issue(t, "client")
+	responder := newFakeResponder(ca)
+	srv := responder.server(t)
+
+	// the responder sets no NextUpdate, so the CacheTTL applies
+	clock := time.Now()
+	checker := revocation.NewOCSPChecker(revocation.OCSPConfig{
+		ResponderURL: srv.URL,
+		CacheTTL:     time.Minute,
+		Now:          func() time.Time { return clock },
+	})
+
+	expectRevoked(t, checker.CheckChain(context.Background(), client), false)
+	responder.revoke(client[0])
+	expectRevoked(t, checker.CheckChain(context.Background(), client), false)
+
+	clock = clock.Add(2 * time.Minute)
+	expectRevoked(t, checker.CheckChain(context.Background(), client), true)
+	if n := responder.count(); n != 2 {
+		t.Errorf("expected 2 responder queries, got %d", n)
+	}
+}
+
+func TestOCSPResponderFromAIA(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newFakeResponder(ca)
+	srv := responder.server(t)
+
+	client := ca.issue(t, "client")
+	client[0].OCSPServer = []string{srv.URL}
+	responder.revoke(client[0])
+
+	checker := revocation.NewOCSPChecker(revocation.OCSPConfig{})
+	expectRevoked(t, checker.CheckChain(context.Background(), client), true)
+}
+
+func TestOCSPSoftAndHardFail(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	client := ca.issue(t, "client")
+	unknown := ca.issue(t, "unknown")
+	responder := newFakeResponder(ca)
+	responder.unknown[unknown[0].SerialNumber.String()] = true
+	srv := responder.server(t)
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		Name      string
+		Responder string
+		Chain     []*x509.Certificate
+		HardFail  bool
+		ExpErr    bool
+	}{
+		{"UnreachableSoft", down.URL, client, false, false},
+		{"UnreachableHard", down.URL, client, true, true},
+		{"UnknownSoft", srv.URL, unknown, false, false},
+		{"UnknownHard", srv.URL, unknown, true, true},
+		{"NoResponderSoft", "", client, false, false},
+		{"NoResponderHard", "", client, true, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			checker := revocation.NewOCSPChecker(revocation.OCSPConfig{
+				ResponderURL: tc.Responder,
+				HardFail:     tc.HardFail,
+			})
+			err := checker.CheckChain(context.Background(), tc.Chain)
+			if (err != nil) != tc.ExpErr {
+				t2.Errorf("expected error=%v, got: %v", tc.ExpErr, err)
+			}
+			expectRevoked(t2, err, false)
+		})
+	}
+}
+
+func TestOCSPStaplePolicy(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	server := ca.issue(t, "server")
+	responder := newFakeResponder(ca)
+	staple := responder.respond(t, server[0].SerialNumber)
+	responder.revoke(server[0])
+	revokedStaple := responder.respond(t, server[0].SerialNumber)
+	srv := responder.server(t)
+
+	tests := []struct {
+		Name    string
+		Policy  revocation.StaplePolicy
+		Staple  []byte
+		Revoked bool
+		ExpErr  bool
+	}{
+		// ignoring the (good) staple, the responder says the cert is revoked
+		{"Ignore", revocation.StapleIgnore, staple, true, true},
+		{"Prefer", revocation.StaplePrefer, staple, false, false},
+		{"PreferRevoked", revocation.StaplePrefer, revokedStaple, true, true},
+		{"PreferMissing", revocation.StaplePrefer, nil, true, true},
+		{"Require", revocation.StapleRequire, staple, false, false},
+		{"RequireMissing", revocation.StapleRequire, nil, false, true},
+		{"RequireInvalid", revocation.StapleRequire, []byte("junk"), false, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			checker := revocation.NewOCSPChecker(revocation.OCSPConfig{
+				ResponderURL: srv.URL,
+				StaplePolicy: tc.Policy,
+			})
+			err := checker.VerifyConnection(tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{server},
+				OCSPResponse:   tc.Staple,
+			})
+			if (err != nil) != tc.ExpErr {
+				t2.Errorf("expected error=%v, got: %v", tc.ExpErr, err)
+			}
+			if errors.Is(err, revocation.ErrRevoked) != tc.Revoked {
+				t2.Errorf("expected revoked=%v, got: %v", tc.Revoked, err)
+			}
+		})
+	}
+}
+
+func TestOCSPStaleResponses(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	server := ca.issue(t, "server")
+	fresh := newFakeResponder(ca)
+	srv := fresh.server(t)
+
+	expired := newFakeResponder(ca)
+	expired.thisUpdate, expired.nextUpdate = -48*time.Hour, -24*time.Hour
+	expiredSrv := expired.server(t)
+	future := newFakeResponder(ca)
+	future.thisUpdate = time.Hour
+
+	tests := []struct {
+		Name      string
+		Responder string
+		Policy    revocation.StaplePolicy
+		Staple    []byte
+		ExpErr    bool
+	}{
+		{"RequireExpired", srv.URL, revocation.StapleRequire, expired.respond(t, server[0].SerialNumber), true},
+		{"RequireFuture", srv.URL, revocation.StapleRequire, future.respond(t, server[0].SerialNumber), true},
+		// a stale staple isn't required, the responder is queried instead
+		{"PreferExpired", srv.URL, revocation.StaplePrefer, expired.respond(t, server[0].SerialNumber), false},
+		{"PreferExpiredResponder", expiredSrv.URL, revocation.StaplePrefer, expired.respond(t, server[0].SerialNumber), true},
+		{"ResponderExpired", expiredSrv.URL, revocation.StapleIgnore, nil, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			checker := revocation.NewOCSPChecker(revocation.OCSPConfig{
+				ResponderURL: tc.Responder,
+				StaplePolicy: tc.Policy,
+				HardFail:     true,
+			})
+			err := checker.VerifyConnection(tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{server},
+				OCSPResponse:   tc.Staple,
+			})
+			if (err != nil) != tc.ExpErr {
+				t2.Errorf("expected error=%v, got: %v", tc.ExpErr, err)
+			}
+		})
+	}
+
+	// within the clock skew responses are still current
+	skewed := newFakeResponder(ca)
+	skewed.thisUpdate, skewed.nextUpdate = -time.Hour, -time.Minute
+	checker := revocation.NewOCSPChecker(revocation.OCSPConfig{
+		ResponderURL: skewed.server(t).URL,
+		HardFail:     true,
+	})
+	if err := checker.CheckChain(context.Background(), server); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestOCSPNegativeCache(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	client := ca.issue(t, "client")
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	clock := time.Now()
+	checker := revocation.NewOCSPChecker(revocation.OCSPConfig{
+		ResponderURL: down.URL,
+		Now:          func() time.Time { return clock },
+	})
+	for i := 0; i < 3; i++ {
+		if err := checker.CheckChain(context.Background(), client); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	stats := checker.Stats()
+	if stats.Queries != 1 || stats.Errors != 1 || stats.CacheHits != 2 {
+		t.Errorf("expected the failure to be cached, got stats: %+v", stats)
+	}
+
+	// the failure is forgotten after the NegativeCacheTTL
+	clock = clock.Add(time.Minute)
+	checker.CheckChain(context.Background(), client)
+	if stats := checker.Stats(); stats.Queries != 2 {
+		t.Errorf("expected the responder to be queried again, got stats: %+v", stats)
+	}
+
+	// cached failures still fail hard when required
+	checker = revocation.NewOCSPChecker(revocation.OCSPConfig{ResponderURL: down.URL, HardFail: true})
+	for i := 0; i < 2; i++ {
+		if err := checker.CheckChain(context.Background(), client); err == nil {
+			t.Error("expected the cached failure to fail hard")
+		}
+	}
+}
+
+func TestOCSPCacheSize(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newFakeResponder(ca)
+	srv := responder.server(t)
+
+	clock := time.Now()
+	checker := revocation.NewOCSPChecker(revocation.OCSPConfig{
+		ResponderURL: srv.URL,
+		CacheSize:    2,
+		Now:          func() time.Time { return clock },
+	})
+	chains := [][]*x509.Certificate{ca.issue(t, "a"), ca.issue(t, "b"), ca.issue(t, "c")}
+	for _, chain := range chains {
+		if err := checker.CheckChain(context.Background(), chain); err != nil {
+			t.Fatal(err)
+		}
+		clock = clock.Add(time.Second)
+	}
+	// "a" expired soonest so it was evicted for "c"
+	for _, chain := range [][]*x509.Certificate{chains[2], chains[1], chains[0]} {
+		if err := checker.CheckChain(context.Background(), chain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := responder.count(); n != 4 {
+		t.Errorf("expected 4 responder queries, got %d", n)
+	}
+}
@@ -0,0 +1,415 @@
+package certauth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// ErrNoRoute is returned when routes are configured, the request matches none of them and the
+// Auth has no default checkers
+var ErrNoRoute = errors.New("no authorization route matches the request")
+
+// ErrAmbiguousRoute is returned when a request's escaped and unescaped paths match different
+// routes, e.g. `/admin%2Fdelete`: http.ServeMux routes on the former but httprouter and gin on
+// the latter, so the request could reach a handler the matching route doesn't protect
+var ErrAmbiguousRoute = errors.New("request path matches different authorization routes once unescaped")
+
+// RoutePattern matches requests by method, host and path. Patterns are written
+// `[METHOD ][HOST]/PATH`, accepting both `http.ServeMux` (Go 1.22+) and `httprouter` syntax for
+// the path, whose `/` separated segments are:
+//   - a literal, matching itself
+//   - `{name}` or `:name`, matching any one non-empty segment which is captured as the route
+//     param `name`
+//   - `*`, matching any one non-empty segment without capturing it
+//   - a final `{name...}` or `*name`, matching the rest of the path, captured as `name`
+//   - a final `**`, matching the rest of the path without capturing it
+//
+// As with http.ServeMux, a pattern ending in a slash matches every path below it unless it ends
+// in `{$}`, `GET` patterns also match `HEAD` requests, and patterns without a method or host
+// match any method or host. Patterns matching the rest of the path require the slash before it:
+// `/files/{path...}` matches `/files/` but not `/files`. Segments are matched once unescaped, so
+// `/files/{name}` matches `/files/a%2Fb` with `name` set to `a/b`.
+type RoutePattern struct {
+	Method string
+	Host   string
+	Path   string
+
+	segments []segment
+	// tree is set when the pattern matches the rest of the path after its segments
+	tree bool
+}
+
+type segment struct {
+	literal  string
+	param    string
+	wildcard bool
+	rest     bool
+}
+
+// ParseRoutePattern parses a RoutePattern, see RoutePattern for the syntax
+func ParseRoutePattern(pattern string) (*RoutePattern, error) {
+	p := &RoutePattern{}
+	rest := strings.TrimSpace(pattern)
+	if i := strings.IndexAny(rest, " \t"); i >= 0 {
+		p.Method, rest = rest[:i], strings.TrimLeft(rest[i:], " \t")
+		if p.Method == "" || strings.ToUpper(p.Method) != p.Method {
+			return nil, fmt.Errorf("route %q has an invalid method %q", pattern, p.Method)
+		}
+	}
+	i := strings.Index(rest, "/")
+	if i < 0 {
+		return nil, fmt.Errorf("route %q has no path, paths must start with /", pattern)
+	}
+	p.Host, p.Path = rest[:i], rest[i:]
+
+	parts := strings.Split(p.Path[1:], "/")
+	seen := map[string]bool{}
+	for i, part := range parts {
+		last := i == len(parts)-1
+		var seg segment
+		switch {
+		case part == "{$}":
+			if !last {
+				return nil, fmt.Errorf("route %q may only use {$} in its final segment", pattern)
+			}
+			// an empty final literal matches the trailing slash only
+		case part == "" && last:
+			p.tree = true
+			continue
+		case part == "**":
+			seg.rest = true
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "...}"):
+			seg.rest, seg.param = true, strings.TrimSuffix(part[1:], "...}")
+		case strings.HasPrefix(part, "*") && len(part) > 1:
+			seg.rest, seg.param = true, part[1:]
+		case part == "*":
+			seg.wildcard = true
+		case strings.HasPrefix(part, ":") && len(part) > 1:
+			seg.wildcard, seg.param = true, part[1:]
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") && len(part) > 2:
+			seg.wildcard, seg.param = true, part[1:len(part)-1]
+		case strings.ContainsAny(part, "*{}") || strings.HasPrefix(part, ":"):
+			return nil, fmt.Errorf("route %q has an invalid segment %q", pattern, part)
+		default:
+			lit, err := url.PathUnescape(part)
+			if err != nil {
+				return nil, fmt.Errorf("route %q has an invalid segment %q", pattern, part)
+			}
+			seg.literal = lit
+		}
+		if seg.rest && !last {
+			return nil, fmt.Errorf(
+				"route %q may only match the rest of the path in its final segment", pattern)
+		}
+		if seg.param != "" {
+			if strings.ContainsAny(seg.param, "{}:*$") {
+				return nil, fmt.Errorf("route %q has an invalid param name %q", pattern, seg.param)
+			}
+			if seen[seg.param] {
+				return nil, fmt.Errorf("route %q uses the param %q more than once", pattern, seg.param)
+			}
+			seen[seg.param] = true
+		}
+		p.segments = append(p.segments, seg)
+	}
+	return p, nil
+}
+
+// String returns the pattern in `[METHOD ][HOST]/PATH` form
+func (p *RoutePattern) String() string {
+	if p.Method == "" {
+		return p.Host + p.Path
+	}
+	return p.Method + " " + p.Host + p.Path
+}
+
+// Match reports whether a request matches the pattern, returning the params it captures
+func (p *RoutePattern) Match(r *http.Request) (httprouter.Params, bool) {
+	return p.match(r, r.URL.EscapedPath())
+}
+
+// match is Match for the escaped `path`, in place of the request's
+func (p *RoutePattern) match(r *http.Request, path string) (httprouter.Params, bool) {
+	if p.Method != "" && p.Method != r.Method && !(p.Method == http.MethodGet && r.Method == http.MethodHead) {
+		return nil, false
+	}
+	if p.Host != "" {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if host != p.Host {
+			return nil, false
+		}
+	}
+	return p.MatchPath(path)
+}
+
+// MatchPath reports whether `path` matches the path of the pattern, ignoring its method and
+// host, returning the params it captures. `path` is escaped, as returned by url.URL.EscapedPath:
+// like http.ServeMux, it is split on slashes before its segments are unescaped.
+func (p *RoutePattern) MatchPath(path string) (httprouter.Params, bool) {
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	var ps httprouter.Params
+	for i, seg := range p.segments {
+		if i >= len(parts) {
+			return nil, false
+		}
+		if seg.rest {
+			if seg.param != "" {
+				ps = append(ps, httprouter.Param{Key: seg.param, Value: unescape(strings.Join(parts[i:], "/"))})
+			}
+			return ps, true
+		}
+		part := unescape(parts[i])
+		switch {
+		case seg.wildcard:
+			if part == "" {
+				return nil, false
+			}
+			if seg.param != "" {
+				ps = append(ps, httprouter.Param{Key: seg.param, Value: part})
+			}
+		case seg.literal != part:
+			return nil, false
+		}
+	}
+	if p.tree {
+		// the trailing slash must be present, anything may follow it
+		return ps, len(parts) > len(p.segments)
+	}
+	if len(parts) != len(p.segments) {
+		return nil, false
+	}
+	return ps, true
+}
+
+// unescape unescapes a path segment, leaving it as is if it is not validly escaped
+func unescape(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// relationship is how the sets of requests matched by two patterns relate, as in http.ServeMux
+type relationship int
+
+const (
+	equivalent relationship = iota
+	moreGeneral
+	moreSpecific
+	overlaps
+	disjoint
+)
+
+func (r relationship) inverse() relationship {
+	switch r {
+	case moreGeneral:
+		return moreSpecific
+	case moreSpecific:
+		return moreGeneral
+	}
+	return r
+}
+
+func combineRelationships(r1, r2 relationship) relationship {
+	switch r1 {
+	case equivalent:
+		return r2
+	case disjoint:
+		return disjoint
+	case overlaps:
+		if r2 == disjoint {
+			return disjoint
+		}
+		return overlaps
+	}
+	// r1 is moreGeneral or moreSpecific
+	switch r2 {
+	case equivalent:
+		return r1
+	case r1.inverse():
+		return overlaps
+	}
+	return r2
+}
+
+// compare returns how the requests matched by `p` relate to those matched by `o`, ignoring
+// their hosts
+func (p *RoutePattern) compare(o *RoutePattern) relationship {
+	var rel relationship
+	switch {
+	case p.Method == o.Method:
+		rel = equivalent
+	case p.Method == "" || (p.Method == http.MethodGet && o.Method == http.MethodHead):
+		rel = moreGeneral
+	case o.Method == "" || (o.Method == http.MethodGet && p.Method == http.MethodHead):
+		rel = moreSpecific
+	default:
+		return disjoint
+	}
+
+	segs1, segs2 := p.allSegments(), o.allSegments()
+	multi1, multi2 := segs1[len(segs1)-1].rest, segs2[len(segs2)-1].rest
+	for ; len(segs1) > 0 && len(segs2) > 0; segs1, segs2 = segs1[1:], segs2[1:] {
+		if rel = combineRelationships(rel, compareSegments(segs1[0], segs2[0])); rel == disjoint {
+			return rel
+		}
+	}
+	switch {
+	case len(segs1) == 0 && len(segs2) == 0:
+		return rel
+	case len(segs1) < len(segs2) && multi1:
+		return combineRelationships(rel, moreGeneral)
+	case len(segs2) < len(segs1) && multi2:
+		return combineRelationships(rel, moreSpecific)
+	}
+	return disjoint
+}
+
+// allSegments returns the segments of the pattern, ending with a rest segment for a pattern
+// ending in a slash
+func (p *RoutePattern) allSegments() []segment {
+	if !p.tree {
+		return p.segments
+	}
+	return append(p.segments[:len(p.segments):len(p.segments)], segment{rest: true})
+}
+
+func compareSegments(s1, s2 segment) relationship {
+	switch {
+	case s1.rest && s2.rest:
+		return equivalent
+	case s1.rest:
+		return moreGeneral
+	case s2.rest:
+		return moreSpecific
+	case s1.wildcard && s2.wildcard:
+		return equivalent
+	case s1.wildcard:
+		// wildcards never match the empty segment of {$}
+		if s2.literal == "" {
+			return disjoint
+		}
+		return moreGeneral
+	case s2.wildcard:
+		if s1.literal == "" {
+			return disjoint
+		}
+		return moreSpecific
+	case s1.literal == s2.literal:
+		return equivalent
+	}
+	return disjoint
+}
+
+// route is an entry of an Auth's route table
+type route struct {
+	pattern *RoutePattern
+	groups  [][]RequestChecker
+}
+
+// WithRoute configures an Auth with a group of RequestCheckers for the requests matching
+// `pattern`, see RoutePattern for the syntax. Calling WithRoute again with the same pattern adds
+// another group: the route passes when all the checkers in any of its groups pass.
+//
+// Requests matching a route are authorized by the route's groups alone. As with http.ServeMux,
+// when several routes match a request the most specific one is used, whatever the order they were
+// configured in, and routes with a host take precedence over those without: `/admin/` applies to
+// `/admin/delete` even if `/` was configured first. Two routes for the same host which may match
+// the same request without either being more specific, such as `GET /files/` and `/files/{$}`,
+// are rejected. Requests which match no route are authorized by the groups configured with
+// WithCheckers and WithRequestCheckers, or denied with ErrNoRoute if there are none.
+//
+// Requests whose path matches a different route once unescaped, such as `/admin%2Fdelete` when
+// `/admin/` is a route, are denied with ErrAmbiguousRoute whichever router serves them.
+//
+// Params captured by the pattern are passed to the checkers when the request has none of its own,
+// so a single Auth in front of an http.ServeMux can enforce rules which depend on them.
+// An invalid pattern causes New to panic; use Build to handle it as an error.
+func WithRoute(pattern string, checkers ...RequestChecker) AuthOption {
+	return func(a *Auth) {
+		p, err := ParseRoutePattern(pattern)
+		if err != nil {
+			a.errs = append(a.errs, err)
+			return
+		}
+		for _, rt := range a.routes {
+			if rt.pattern.String() == p.String() {
+				rt.groups = append(rt.groups, checkers)
+				return
+			}
+		}
+		for _, rt := range a.routes {
+			if rt.pattern.Host != p.Host {
+				continue
+			}
+			if rel := p.compare(rt.pattern); rel == equivalent || rel == overlaps {
+				a.errs = append(a.errs, fmt.Errorf(
+					"route %q conflicts with route %q: they match the same requests and neither is more specific",
+					p, rt.pattern,
+				))
+				return
+			}
+		}
+		a.routes = append(a.routes, &route{pattern: p, groups: [][]RequestChecker{checkers}})
+	}
+}
+
+// WithRouteCheckers is like WithRoute for AuthorizationCheckers
+func WithRouteCheckers(pattern string, checkers ...AuthorizationChecker) AuthOption {
+	return WithRoute(pattern, adaptCheckers(checkers)...)
+}
+
+// routeGroups returns the checker groups which apply to `req`, the pattern of the route they
+// belong to ("" for the default groups) and the params captured by the pattern
+func (a *Auth) routeGroups(req *AuthRequest) ([][]RequestChecker, string, httprouter.Params, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if req.Request != nil {
+		u := req.Request.URL
+		best, bestPs := a.bestRoute(req.Request, u.EscapedPath())
+		// routers which route on the unescaped path must reach a handler protected by the same
+		// route, so the path is also matched once unescaped (and escaped again, leaving only the
+		// slashes as separators)
+		if unescaped, _ := a.bestRoute(req.Request, (&url.URL{Path: u.Path}).EscapedPath()); unescaped != best {
+			return nil, "", nil, ErrAmbiguousRoute
+		}
+		if best != nil {
+			return best.groups, best.pattern.String(), bestPs, nil
+		}
+	}
+	if len(a.routes) > 0 && len(a.checkers) == 0 {
+		return nil, "", nil, ErrNoRoute
+	}
+	return a.checkers, "", nil, nil
+}
+
+// bestRoute returns the most specific route matching a request with the escaped `path`, and the
+// params it captures, or nil if none does
+func (a *Auth) bestRoute(r *http.Request, path string) (*route, httprouter.Params) {
+	var (
+		best   *route
+		bestPs httprouter.Params
+	)
+	for _, rt := range a.routes {
+		ps, ok := rt.pattern.match(r, path)
+		if !ok {
+			continue
+		}
+		// conflicting routes are rejected, so of two routes with the same host-ness matching the
+		// request one is more specific
+		if best == nil || (best.pattern.Host == "" && rt.pattern.Host != "") ||
+			((best.pattern.Host == "") == (rt.pattern.Host == "") && rt.pattern.compare(best.pattern) == moreSpecific) {
+			best, bestPs = rt, ps
+		}
+	}
+	return best, bestPs
+}
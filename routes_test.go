@@ -0,0 +1,264 @@
+package certauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/pantheon-systems/go-certauth"
+)
+
+func TestRoutePattern(t *testing.T) {
+	tests := []struct {
+		Pattern  string
+		Method   string
+		URL      string
+		ExpMatch bool
+		ExpPs    httprouter.Params
+	}{
+		{"/foo", "GET", "https://foo.bar/foo", true, nil},
+		{"/foo", "GET", "https://foo.bar/foo/", false, nil},
+		{"/foo/", "GET", "https://foo.bar/foo/", true, nil},
+		{"/foo/", "GET", "https://foo.bar/foo/bar/baz", true, nil},
+		{"/foo/", "GET", "https://foo.bar/foo", false, nil},
+		{"/foo/{$}", "GET", "https://foo.bar/foo/", true, nil},
+		{"/foo/{$}", "GET", "https://foo.bar/foo/bar", false, nil},
+		{"/", "GET", "https://foo.bar/anything", true, nil},
+		{"GET /foo", "HEAD", "https://foo.bar/foo", true, nil},
+		{"GET /foo", "POST", "https://foo.bar/foo", false, nil},
+		{"foo.bar/foo", "GET", "https://foo.bar:8443/foo", true, nil},
+		{"baz.bar/foo", "GET", "https://foo.bar/foo", false, nil},
+		{"/sites/{site}/env", "GET", "https://foo.bar/sites/abc/env", true, httprouter.Params{{Key: "site", Value: "abc"}}},
+		{"/sites/:site/env", "GET", "https://foo.bar/sites/abc/env", true, httprouter.Params{{Key: "site", Value: "abc"}}},
+		{"/sites/:site/env", "GET", "https://foo.bar/sites//env", false, nil},
+		{"/sites/*/env", "GET", "https://foo.bar/sites/abc/env", true, nil},
+		{"/files/{path...}", "GET", "https://foo.bar/files/a/b/c", true, httprouter.Params{{Key: "path", Value: "a/b/c"}}},
+		{"/files/*path", "GET", "https://foo.bar/files/a/b", true, httprouter.Params{{Key: "path", Value: "a/b"}}},
+		{"/files/**", "GET", "https://foo.bar/files/a/b", true, nil},
+		{"/files/**", "GET", "https://foo.bar/files", false, nil},
+		{"/files/{path...}", "GET", "https://foo.bar/files", false, nil},
+		{"/files/{path...}", "GET", "https://foo.bar/files/", true, httprouter.Params{{Key: "path", Value: ""}}},
+		{"/files/{path...}", "GET", "https://foo.bar/files/a%2Fb/c", true, httprouter.Params{{Key: "path", Value: "a/b/c"}}},
+		{"/files/{name}", "GET", "https://foo.bar/files/a%2Fb", true, httprouter.Params{{Key: "name", Value: "a/b"}}},
+		{"/files/a%20b", "GET", "https://foo.bar/files/a%20b", true, nil},
+		{"/files/a/b", "GET", "https://foo.bar/files/a%2Fb", false, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Pattern+" "+tc.URL, func(t2 *testing.T) {
+			p, err := certauth.ParseRoutePattern(tc.Pattern)
+			if err != nil {
+				t2.Fatal(err)
+			}
+			r := httptest.NewRequest(tc.Method, tc.URL, nil)
+			ps, ok := p.Match(r)
+			expect(t2, ok, tc.ExpMatch)
+			expect(t2, len(ps), len(tc.ExpPs))
+			for _, param := range tc.ExpPs {
+				expect(t2, ps.ByName(param.Key), param.Value)
+			}
+		})
+	}
+
+	for _, pattern := range []string{"foo", "get /foo", "/a/{path...}/b", "/a/{$}/b", "/a/{x}/{x}", "/a/b{c}", "/a/:"} {
+		if _, err := certauth.ParseRoutePattern(pattern); err == nil {
+			t.Errorf("expected an error for %q", pattern)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	var site string
+	ouChecker := func(ous ...string) certauth.RequestChecker {
+		return certauth.AdaptChecker(certauth.AllowOUsandCNs(ous, nil))
+	}
+	siteChecker := certauth.RequestCheckerFunc(func(_ context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+		site = req.Params.ByName("site")
+		if site != req.Identity.CommonName {
+			return certauth.Deny("wrong site"), nil
+		}
+		return certauth.Allow(nil), nil
+	})
+
+	auth := certauth.New(
+		certauth.WithRoute("GET /sites/{site}/env", ouChecker("site"), siteChecker),
+		certauth.WithRoute("GET /sites/{site}/env", ouChecker("admin")),
+		certauth.WithRouteCheckers("POST /sites/", certauth.AllowOUsandCNs([]string{"admin"}, nil)),
+		certauth.WithCheckers(certauth.AllowOUsandCNs([]string{"endpoint"}, nil)),
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+	handler := auth.Handler(mux)
+
+	tests := []struct {
+		Name    string
+		Method  string
+		Path    string
+		OU      string
+		CN      string
+		ExpCode int
+	}{
+		{"SiteOwn", "GET", "/sites/abc/env", "site", "abc", http.StatusOK},
+		{"SiteOther", "GET", "/sites/def/env", "site", "abc", http.StatusForbidden},
+		{"AdminGroup", "GET", "/sites/def/env", "admin", "x", http.StatusOK},
+		{"DefaultNotUsedForRoute", "GET", "/sites/def/env", "endpoint", "x", http.StatusForbidden},
+		{"AdminPost", "POST", "/sites/abc/env", "admin", "x", http.StatusOK},
+		{"SitePost", "POST", "/sites/abc/env", "site", "abc", http.StatusForbidden},
+		{"Default", "GET", "/other", "endpoint", "x", http.StatusOK},
+		{"DefaultDenied", "GET", "/other", "site", "abc", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.Method, "https://foo.bar"+tc.Path, nil)
+			req.TLS = &tls.ConnectionState{
+				VerifiedChains: fakeCertChain(fakeCertData{ou: []string{tc.OU}, cn: tc.CN}),
+			}
+			handler.ServeHTTP(w, req)
+			expect(t2, w.Code, tc.ExpCode)
+		})
+	}
+
+	// routes apply in the same way behind httprouter, which provides its own params
+	router := httprouter.New()
+	router.GET("/sites/:site/env", auth.RouterHandler(func(http.ResponseWriter, *http.Request, httprouter.Params) {}))
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "https://foo.bar/sites/abc/env", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: fakeCertChain(fakeCertData{ou: []string{"site"}, cn: "abc"})}
+	router.ServeHTTP(w, req)
+	expect(t, w.Code, http.StatusOK)
+	expect(t, site, "abc")
+}
+
+func TestRoutePrecedence(t *testing.T) {
+	auth := certauth.New(
+		// broad routes configured first must not shadow narrower ones
+		certauth.WithRouteCheckers("/", certauth.AllowOUsandCNs([]string{"anyone", "admin"}, nil)),
+		certauth.WithRouteCheckers("/admin/", certauth.AllowOUsandCNs([]string{"admin"}, nil)),
+		certauth.WithRouteCheckers("/admin/public/{page}", certauth.AllowOUsandCNs([]string{"anyone"}, nil)),
+		certauth.WithRouteCheckers("internal.bar/", certauth.AllowOUsandCNs([]string{"internal"}, nil)),
+	)
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		URL     string
+		OU      string
+		ExpCode int
+	}{
+		{"https://foo.bar/other", "anyone", http.StatusOK},
+		{"https://foo.bar/admin/delete", "anyone", http.StatusForbidden},
+		{"https://foo.bar/admin/delete", "admin", http.StatusOK},
+		{"https://foo.bar/admin/public/help", "anyone", http.StatusOK},
+		{"https://foo.bar/admin/public/help", "admin", http.StatusForbidden},
+		// routes with a host take precedence over more specific ones without
+		{"https://internal.bar/admin/delete", "internal", http.StatusOK},
+		{"https://internal.bar/admin/delete", "admin", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.URL+" "+tc.OU, func(t2 *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", tc.URL, nil)
+			req.TLS = &tls.ConnectionState{VerifiedChains: fakeCertChain(fakeCertData{ou: []string{tc.OU}, cn: "x"})}
+			handler.ServeHTTP(w, req)
+			expect(t2, w.Code, tc.ExpCode)
+		})
+	}
+
+	// routes which match the same requests without one being more specific are rejected
+	conflicts := [][2]string{
+		{"GET /files/", "/files/{$}"},
+		{"/sites/{site}", "/sites/:name"},
+		{"/sites/{site}/env", "/sites/abc/{page}"},
+	}
+	for _, c := range conflicts {
+		_, err := certauth.Build(certauth.WithRouteCheckers(c[0]), certauth.WithRouteCheckers(c[1]))
+		if err == nil {
+			t.Errorf("expected %q and %q to conflict", c[0], c[1])
+		}
+	}
+	_, err := certauth.Build(
+		certauth.WithRouteCheckers("GET /files/"),
+		certauth.WithRouteCheckers("POST /files/{$}"),
+		certauth.WithRouteCheckers("foo.bar/files/{name}"),
+		certauth.WithRouteCheckers("GET /files/{name}"),
+	)
+	expectErr(t, err, nil)
+}
+
+func TestRoutesEscapedPath(t *testing.T) {
+	deny := certauth.RequestCheckerFunc(func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+		return certauth.Deny("admins only"), nil
+	})
+	allow := certauth.RequestCheckerFunc(func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+		return certauth.Allow(nil), nil
+	})
+	auth := certauth.New(certauth.WithRoute("/admin/", deny), certauth.WithRequestCheckers(allow))
+
+	// httprouter routes on the unescaped path, so /admin%2Fdelete reaches the admin handler
+	var reached string
+	rtr := httprouter.New()
+	rtr.GET("/admin/delete", auth.RouterHandler(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		reached = "admin"
+	}))
+	rtr.GET("/public/:name", auth.RouterHandler(func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		reached = "public"
+	}))
+
+	tests := []struct {
+		Path    string
+		ExpCode int
+		ExpErr  error
+	}{
+		{"/admin/delete", http.StatusForbidden, nil},
+		{"/admin%2Fdelete", http.StatusForbidden, certauth.ErrAmbiguousRoute},
+		{"/public/page", http.StatusOK, nil},
+		{"/public/a%20page", http.StatusOK, nil},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Path, func(t2 *testing.T) {
+			reached = ""
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "https://foo.bar"+tc.Path, nil)
+			req.TLS = &tls.ConnectionState{VerifiedChains: fakeCertChain(fakeCertData{cn: "foo.com"})}
+			rtr.ServeHTTP(w, req)
+			expect(t2, w.Code, tc.ExpCode)
+			if tc.ExpCode == http.StatusForbidden {
+				expect(t2, reached, "")
+			}
+		})
+		if tc.ExpErr != nil {
+			_, err := auth.Authorize(context.Background(), &certauth.AuthRequest{
+				Certificate: fakeCertChain(fakeCertData{cn: "foo.com"})[0][0],
+				Request:     httptest.NewRequest("GET", "https://foo.bar"+tc.Path, nil),
+			})
+			expectErr(t, err, tc.ExpErr)
+		}
+	}
+}
+
+func TestRoutesWithoutDefault(t *testing.T) {
+	auth := certauth.New(certauth.WithRouteCheckers("/health"))
+	_, err := auth.Authorize(context.Background(), &certauth.AuthRequest{
+		Certificate: fakeCertChain(fakeCertData{cn: "foo.com"})[0][0],
+		Request:     httptest.NewRequest("GET", "https://foo.bar/other", nil),
+	})
+	if !errors.Is(err, certauth.ErrNoRoute) {
+		t.Errorf("expected ErrNoRoute, got %v", err)
+	}
+}
+
+func TestInvalidRoute(t *testing.T) {
+	if _, err := certauth.Build(certauth.WithRouteCheckers("health")); err == nil {
+		t.Error("expected an error for an invalid route")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("expected New to panic for an invalid route")
+		}
+	}()
+	certauth.New(certauth.WithRouteCheckers("health"))
+}
@@ -0,0 +1,176 @@
+package certauth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// SPIFFEIDKey is used as the request context key holding the client's SPIFFEID when it is
+// authorized by a SPIFFEChecker
+const SPIFFEIDKey = contextKey("SPIFFE ID")
+
+// SPIFFEID is a parsed SPIFFE ID, `spiffe://<trust domain><path>`
+type SPIFFEID struct {
+	TrustDomain string
+	// Path is empty or starts with `/`
+	Path string
+}
+
+// String returns the SPIFFE ID as a URI
+func (id SPIFFEID) String() string {
+	return "spiffe://" + id.TrustDomain + id.Path
+}
+
+// ParseSPIFFEID parses and validates a SPIFFE ID following the SPIFFE ID specification: the
+// trust domain may only contain lowercase letters, digits, `.`, `-` and `_`, and each segment of
+// the path may only contain letters, digits, `.`, `-` and `_` and may not be empty, `.` or `..`.
+func ParseSPIFFEID(s string) (SPIFFEID, error) {
+	if len(s) > 2048 {
+		return SPIFFEID{}, errors.New("SPIFFE ID is longer than 2048 bytes")
+	}
+	rest, ok := strings.CutPrefix(s, "spiffe://")
+	if !ok {
+		return SPIFFEID{}, fmt.Errorf("%q is not a SPIFFE ID", s)
+	}
+	td, path := rest, ""
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		td, path = rest[:i], rest[i:]
+	}
+
+	if td == "" {
+		return SPIFFEID{}, fmt.Errorf("SPIFFE ID %q has no trust domain", s)
+	}
+	if len(td) > 255 {
+		return SPIFFEID{}, fmt.Errorf("SPIFFE ID %q has a trust domain longer than 255 bytes", s)
+	}
+	for _, c := range td {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '.' || c == '-' || c == '_') {
+			return SPIFFEID{}, fmt.Errorf("SPIFFE ID %q has an invalid trust domain", s)
+		}
+	}
+
+	if path != "" {
+		for _, seg := range strings.Split(path[1:], "/") {
+			if seg == "" || seg == "." || seg == ".." {
+				return SPIFFEID{}, fmt.Errorf("SPIFFE ID %q has an invalid path segment %q", s, seg)
+			}
+			for _, c := range seg {
+				if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+					c == '.' || c == '-' || c == '_') {
+					return SPIFFEID{}, fmt.Errorf("SPIFFE ID %q has an invalid path segment %q", s, seg)
+				}
+			}
+		}
+	}
+	return SPIFFEID{TrustDomain: td, Path: path}, nil
+}
+
+// SPIFFEIDFromCert returns the SPIFFE ID of an X.509-SVID, which must have exactly one URI SAN
+func SPIFFEIDFromCert(cert *x509.Certificate) (SPIFFEID, error) {
+	if cert == nil {
+		return SPIFFEID{}, errors.New("no client certificate")
+	}
+	if len(cert.URIs) != 1 {
+		return SPIFFEID{}, fmt.Errorf(
+			"cert has %d URI SANs, an X.509-SVID must have exactly one", len(cert.URIs))
+	}
+	return ParseSPIFFEID(cert.URIs[0].String())
+}
+
+// SPIFFEIDFromContext returns the client's SPIFFE ID added to the request context by a
+// SPIFFEChecker
+func SPIFFEIDFromContext(ctx context.Context) (SPIFFEID, bool) {
+	id, ok := ctx.Value(SPIFFEIDKey).(SPIFFEID)
+	return id, ok
+}
+
+// SPIFFEChecker is a RequestChecker which authorizes clients presenting an X.509-SVID by the
+// trust domain and path of their SPIFFE ID, adding the ID to the request context under
+// SPIFFEIDKey. The CN of the certificate is ignored.
+type SPIFFEChecker struct {
+	trustDomains []string
+	paths        []*RoutePattern
+}
+
+// NewSPIFFEChecker creates a SPIFFEChecker allowing SPIFFE IDs in one of `trustDomains` whose
+// path matches one of `pathPatterns`. Path patterns use the syntax of RoutePattern without a
+// method or host, e.g. `/ns/{namespace}/sa/*` or `/ns/prod/` for every path below `/ns/prod`.
+// Either list may be empty, which disables checking that part of the ID.
+func NewSPIFFEChecker(trustDomains, pathPatterns []string) (*SPIFFEChecker, error) {
+	c := &SPIFFEChecker{}
+	for _, td := range trustDomains {
+		id, err := ParseSPIFFEID("spiffe://" + td)
+		if err != nil || id.Path != "" {
+			return nil, fmt.Errorf("invalid SPIFFE trust domain %q", td)
+		}
+		c.trustDomains = append(c.trustDomains, td)
+	}
+	for _, raw := range pathPatterns {
+		if !strings.HasPrefix(raw, "/") {
+			return nil, fmt.Errorf("SPIFFE path pattern %q must start with /", raw)
+		}
+		p, err := ParseRoutePattern(raw)
+		if err != nil {
+			return nil, err
+		}
+		c.paths = append(c.paths, p)
+	}
+	return c, nil
+}
+
+// WithSPIFFE configures an Auth with a group holding the SPIFFEChecker built by
+// NewSPIFFEChecker. An invalid trust domain or path pattern causes New to panic; use Build to
+// handle it as an error.
+func WithSPIFFE(trustDomains, pathPatterns []string) AuthOption {
+	return func(a *Auth) {
+		c, err := NewSPIFFEChecker(trustDomains, pathPatterns)
+		if err != nil {
+			a.errs = append(a.errs, err)
+			return
+		}
+		WithRequestCheckers(c)(a)
+	}
+}
+
+// Check implements RequestChecker
+func (c *SPIFFEChecker) Check(ctx context.Context, req *AuthRequest) (Decision, error) {
+	id, err := SPIFFEIDFromCert(req.Certificate)
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(c.trustDomains) > 0 && !containsString(c.trustDomains, id.TrustDomain) {
+		return Deny(fmt.Sprintf(
+			"cert failed SPIFFE trust domain validation for %q, allowed: %v", id.TrustDomain, c.trustDomains,
+		)), nil
+	}
+	if len(c.paths) > 0 && !c.allowedPath(id.Path) {
+		return Deny(fmt.Sprintf(
+			"cert failed SPIFFE path validation for %q, allowed: %v", id.Path, c.paths,
+		)), nil
+	}
+	return Allow(map[ContextKey]ContextValue{SPIFFEIDKey: id}), nil
+}
+
+func (c *SPIFFEChecker) allowedPath(path string) bool {
+	if path == "" {
+		path = "/"
+	}
+	for _, p := range c.paths {
+		if _, ok := p.MatchPath(path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package certauth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+func TestParseSPIFFEID(t *testing.T) {
+	valid := map[string]certauth.SPIFFEID{
+		"spiffe://example.org":               {TrustDomain: "example.org"},
+		"spiffe://example.org/ns/prod/sa/db": {TrustDomain: "example.org", Path: "/ns/prod/sa/db"},
+		"spiffe://my_td-1.org/Worker.v2":     {TrustDomain: "my_td-1.org", Path: "/Worker.v2"},
+	}
+	for s, exp := range valid {
+		id, err := certauth.ParseSPIFFEID(s)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", s, err)
+		}
+		expect(t, id, exp)
+		expect(t, id.String(), s)
+	}
+
+	for _, s := range []string{
+		"https://example.org/foo",
+		"spiffe://",
+		"spiffe:///foo",
+		"spiffe://Example.org/foo",
+		"spiffe://example.org:8080/foo",
+		"spiffe://user@example.org/foo",
+		"spiffe://example.org/",
+		"spiffe://example.org/foo//bar",
+		"spiffe://example.org/foo/../bar",
+		"spiffe://example.org/foo?bar",
+	} {
+		if _, err := certauth.ParseSPIFFEID(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func svidChain(uris ...string) [][]*x509.Certificate {
+	cert := &x509.Certificate{}
+	for _, u := range uris {
+		parsed, _ := url.Parse(u)
+		cert.URIs = append(cert.URIs, parsed)
+	}
+	return [][]*x509.Certificate{{cert}}
+}
+
+func TestSPIFFEChecker(t *testing.T) {
+	auth := certauth.New(certauth.WithSPIFFE([]string{"example.org"}, []string{"/ns/prod/", "/ns/{ns}/sa/admin"}))
+
+	var seen certauth.SPIFFEID
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = certauth.SPIFFEIDFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		Name    string
+		Chains  [][]*x509.Certificate
+		ExpCode int
+		ExpID   string
+	}{
+		{"Allowed", svidChain("spiffe://example.org/ns/prod/sa/db"), http.StatusOK, "spiffe://example.org/ns/prod/sa/db"},
+		{"AllowedParam", svidChain("spiffe://example.org/ns/dev/sa/admin"), http.StatusOK, "spiffe://example.org/ns/dev/sa/admin"},
+		{"WrongPath", svidChain("spiffe://example.org/ns/dev/sa/db"), http.StatusForbidden, ""},
+		{"WrongTrustDomain", svidChain("spiffe://other.org/ns/prod/sa/db"), http.StatusForbidden, ""},
+		{"NoURI", svidChain(), http.StatusForbidden, ""},
+		{"TwoURIs", svidChain("spiffe://example.org/ns/prod/a", "spiffe://example.org/ns/prod/b"), http.StatusForbidden, ""},
+		{"NotSPIFFE", svidChain("https://example.org/ns/prod/a"), http.StatusForbidden, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.Name, func(t2 *testing.T) {
+			seen = certauth.SPIFFEID{}
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "https://foo.bar/", nil)
+			req.TLS = &tls.ConnectionState{VerifiedChains: tc.Chains}
+			handler.ServeHTTP(w, req)
+			expect(t2, w.Code, tc.ExpCode)
+			if tc.ExpID != "" {
+				expect(t2, seen.String(), tc.ExpID)
+			}
+		})
+	}
+
+	for _, args := range [][2][]string{{{"Example.org"}, nil}, {nil, {"ns/prod"}}, {nil, {"/ns/{a}/{a}"}}} {
+		if _, err := certauth.NewSPIFFEChecker(args[0], args[1]); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
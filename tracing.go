@@ -0,0 +1,52 @@
+package certauth
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer traces the requests processed by an Auth. See the oteltracing package for an
+// OpenTelemetry implementation.
+type Tracer interface {
+	// Start is called when an Auth starts processing a request, with the request's context. The
+	// returned context is passed to the checkers, so spans they start are children of the
+	// request's span; the request passed on to the next handler keeps its own context.
+	Start(ctx context.Context, r *http.Request) (context.Context, Span)
+}
+
+// Span traces the processing of one request. Its methods are called from the goroutine processing
+// the request.
+type Span interface {
+	// CheckerResult is called after each checker runs. `route` is the pattern of the route the
+	// checker belongs to, "" for the default checkers, and `group` and `checker` are the indexes
+	// of the group and of the checker within it. `err` is nil if the checker passed.
+	CheckerResult(route string, group, checker int, err error)
+
+	// End is called once the request is allowed or denied, with the AuditEvent describing the
+	// decision and the error it was denied with, if any
+	End(ev AuditEvent, err error)
+}
+
+// WithTracer configures an Auth to trace every request processed by its Handler, RouterHandler,
+// Process, ProcessWithParams and AuthorizeRequest with `t`. Requests are not traced by default.
+func WithTracer(t Tracer) AuthOption {
+	return func(a *Auth) {
+		a.tracer = t
+	}
+}
+
+const spanKey = contextKey("Span")
+
+// startSpan starts tracing a request, returning the context to run its checkers with
+func (a *Auth) startSpan(r *http.Request) (context.Context, Span) {
+	if a.tracer == nil {
+		return r.Context(), nil
+	}
+	ctx, span := a.tracer.Start(r.Context(), r)
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+func spanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey).(Span)
+	return span
+}
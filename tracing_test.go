@@ -0,0 +1,61 @@
+package certauth_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pantheon-systems/go-certauth"
+)
+
+type recordingTracer struct {
+	results []string
+	ended   []certauth.AuditEvent
+}
+
+func (t *recordingTracer) Start(ctx context.Context, r *http.Request) (context.Context, certauth.Span) {
+	return context.WithValue(ctx, ctxKey("span"), "traced"), t
+}
+
+func (t *recordingTracer) CheckerResult(route string, group, checker int, err error) {
+	t.results = append(t.results, fmt.Sprintf("%d/%d: %v", group, checker, err))
+}
+
+func (t *recordingTracer) End(ev certauth.AuditEvent, err error) {
+	t.ended = append(t.ended, ev)
+}
+
+func TestTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	var checkerSpan, handlerSpan interface{}
+	auth := certauth.New(
+		certauth.WithCheckers(certauth.AllowOUsandCNs(nil, []string{"admin"})),
+		certauth.WithRequestCheckers(certauth.RequestCheckerFunc(
+			func(ctx context.Context, req *certauth.AuthRequest) (certauth.Decision, error) {
+				checkerSpan = ctx.Value(ctxKey("span"))
+				return certauth.Allow(nil), nil
+			},
+		)),
+		certauth.WithTracer(tracer),
+	)
+	handler := auth.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = r.Context().Value(ctxKey("span"))
+	}))
+
+	req := httptest.NewRequest("GET", "https://foo.bar/", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: fakeCertChain(fakeCertData{nil, "foo.com"})}
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// the checkers run with the tracer's context, the handler with the request's
+	expect(t, checkerSpan, "traced")
+	expect(t, handlerSpan, nil)
+	expect(t, len(tracer.results), 2)
+	expect(t, tracer.results[0], "0/0: "+mkCNErr("foo.com", "admin").Error())
+	expect(t, tracer.results[1], "1/0: <nil>")
+	expect(t, len(tracer.ended), 1)
+	expect(t, tracer.ended[0].Allowed, true)
+	expect(t, tracer.ended[0].Subject, "CN=foo.com")
+}